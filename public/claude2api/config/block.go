@@ -0,0 +1,84 @@
+package config
+
+import (
+	"claude2api/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionBlock 会话因上游拦截而进入冷却的原因和截止时间
+type SessionBlock struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+var (
+	sessionBlocks   = map[string]SessionBlock{}
+	sessionBlocksMu sync.Mutex
+	// egressBlocks 代理池为空时按出口（代理地址，直连为空字符串）记录的拦截
+	egressBlocks = map[string]SessionBlock{}
+)
+
+// BlockCooldownDuration 返回被拦截的会话或代理的冷却时间
+func (c *Config) BlockCooldownDuration() time.Duration {
+	return time.Duration(c.BlockCooldown) * time.Second
+}
+
+// BlockSession 将池条目标记为被拦截，冷却期间不会被选中
+func BlockSession(sessionID, reason string, cooldown time.Duration) {
+	sessionBlocksMu.Lock()
+	defer sessionBlocksMu.Unlock()
+	sessionBlocks[sessionID] = SessionBlock{Reason: reason, Until: time.Now().Add(cooldown)}
+	logger.Warn(fmt.Sprintf("Session blocked for %s: %s", cooldown, reason))
+}
+
+// GetSessionBlock 返回池条目当前的拦截状态，冷却结束后自动清除
+func GetSessionBlock(sessionID string) (SessionBlock, bool) {
+	sessionBlocksMu.Lock()
+	defer sessionBlocksMu.Unlock()
+	return activeBlock(sessionBlocks, sessionID)
+}
+
+// BlockEgress 将代理池之外的出口标记为被拦截，proxyURL 为空表示直连。
+// Cloudflare 按出口 IP 拦截，冷却期间使用该出口的所有会话都不会被选中
+func BlockEgress(proxyURL, reason string, cooldown time.Duration) {
+	sessionBlocksMu.Lock()
+	defer sessionBlocksMu.Unlock()
+	egressBlocks[proxyURL] = SessionBlock{Reason: reason, Until: time.Now().Add(cooldown)}
+	if proxyURL == "" {
+		logger.Warn(fmt.Sprintf("Direct egress blocked for %s: %s", cooldown, reason))
+	} else {
+		logger.Warn(fmt.Sprintf("Proxy %s blocked for %s: %s", proxyURL, cooldown, reason))
+	}
+}
+
+// GetEgressBlock 返回出口当前的拦截状态，冷却结束后自动清除
+func GetEgressBlock(proxyURL string) (SessionBlock, bool) {
+	sessionBlocksMu.Lock()
+	defer sessionBlocksMu.Unlock()
+	return activeBlock(egressBlocks, proxyURL)
+}
+
+// GetEffectiveBlock 返回池条目自身或其出口（代理池为空时）的拦截状态
+func GetEffectiveBlock(session SessionInfo) (SessionBlock, bool) {
+	if block, ok := GetSessionBlock(session.ID()); ok {
+		return block, true
+	}
+	if egress, ok := Proxies.DirectEgress(session); ok {
+		return GetEgressBlock(egress)
+	}
+	return SessionBlock{}, false
+}
+
+func activeBlock(blocks map[string]SessionBlock, key string) (SessionBlock, bool) {
+	block, ok := blocks[key]
+	if !ok {
+		return SessionBlock{}, false
+	}
+	if time.Now().After(block.Until) {
+		delete(blocks, key)
+		return SessionBlock{}, false
+	}
+	return block, true
+}
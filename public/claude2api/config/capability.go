@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 账号套餐
+const (
+	PlanFree = "free"
+	PlanPro  = "pro"
+	PlanMax  = "max"
+	PlanTeam = "team"
+)
+
+// claude.ai 网页端对所有套餐的上传限制（单次消息的文件数和单个文件的大小），未在 planLimits 中配置时使用
+const (
+	defaultMaxUploadFiles = 20
+	defaultMaxUploadBytes = 30 << 20
+)
+
+// 未在 planModels 中配置时各套餐可用的模型，"*" 表示不限制
+var defaultPlanModels = map[string][]string{
+	PlanFree: {"claude-3-7-sonnet-*", "claude-3-5-sonnet-*", "claude-3-5-haiku-*"},
+	PlanPro:  {"*"},
+	PlanMax:  {"*"},
+	PlanTeam: {"*"},
+}
+
+// PlanLimits 套餐的思考模式和上传限制。claude.ai 的接口不提供这些信息，由 planLimits 按账号的实际情况配置，
+// 未填写的字段使用默认值
+type PlanLimits struct {
+	// Thinking 能否使用 -think 模型，默认只有免费套餐不能使用
+	Thinking *bool `yaml:"thinking"`
+	// MaxUploadFiles 单条消息最多上传的文件数
+	MaxUploadFiles int `yaml:"maxUploadFiles"`
+	// MaxUploadMB 单个文件的大小上限
+	MaxUploadMB int `yaml:"maxUploadMB"`
+}
+
+func (l PlanLimits) String() string {
+	thinking := "default"
+	if l.Thinking != nil {
+		thinking = fmt.Sprint(*l.Thinking)
+	}
+	return fmt.Sprintf("thinking=%s maxUploadFiles=%d maxUploadMB=%d", thinking, l.MaxUploadFiles, l.MaxUploadMB)
+}
+
+// SessionCapabilities 记录会话所属组织的套餐和能力。
+// 套餐从组织的 rate_limit_tier 和 capabilities 检测；claude.ai 不提供可用模型和上传限制，
+// Models 来自 planModels，Thinking 和上传限制来自 planLimits，都按检测到的套餐取值。
+// 由 SessionSelector.Matches 在选择会话时检查
+type SessionCapabilities struct {
+	OrgID          string    `json:"orgID"`
+	Tier           string    `json:"tier"`
+	Plan           string    `json:"plan"`
+	Models         []string  `json:"models"`
+	Thinking       bool      `json:"thinking"`
+	MaxUploadFiles int       `json:"maxUploadFiles"`
+	MaxUploadBytes int64     `json:"maxUploadBytes"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+var (
+	capabilities   = map[string]SessionCapabilities{}
+	capabilitiesMu sync.RWMutex
+)
+
+// DetectPlan 根据组织的 rate_limit_tier 和 capabilities 推断套餐
+func DetectPlan(tier string, orgCapabilities []string) string {
+	switch {
+	case hasTag(orgCapabilities, "claude_max") || strings.HasPrefix(tier, "default_claude_max"):
+		return PlanMax
+	case hasTag(orgCapabilities, "raven") || strings.Contains(tier, "raven"):
+		return PlanTeam
+	case hasTag(orgCapabilities, "claude_pro") || strings.Contains(tier, "claude_pro"):
+		return PlanPro
+	default:
+		return PlanFree
+	}
+}
+
+// DetectCapabilities 根据组织信息计算会话能力
+func (c *Config) DetectCapabilities(orgID, tier string, orgCapabilities []string) SessionCapabilities {
+	plan := DetectPlan(tier, orgCapabilities)
+
+	c.RwMutx.RLock()
+	models, ok := c.PlanModels[plan]
+	limits := c.PlanLimits[plan]
+	c.RwMutx.RUnlock()
+	if !ok {
+		models = defaultPlanModels[plan]
+	}
+
+	caps := SessionCapabilities{
+		OrgID:          orgID,
+		Tier:           tier,
+		Plan:           plan,
+		Models:         models,
+		Thinking:       plan != PlanFree,
+		MaxUploadFiles: defaultMaxUploadFiles,
+		MaxUploadBytes: defaultMaxUploadBytes,
+		UpdatedAt:      time.Now(),
+	}
+	if limits.Thinking != nil {
+		caps.Thinking = *limits.Thinking
+	}
+	if limits.MaxUploadFiles > 0 {
+		caps.MaxUploadFiles = limits.MaxUploadFiles
+	}
+	if limits.MaxUploadMB > 0 {
+		caps.MaxUploadBytes = int64(limits.MaxUploadMB) << 20
+	}
+	return caps
+}
+
+// SupportsModel 判断会话能否使用指定模型，-think 后缀需要思考模式支持
+func (sc SessionCapabilities) SupportsModel(model string) bool {
+	if strings.HasSuffix(model, "-think") {
+		if !sc.Thinking {
+			return false
+		}
+		model = strings.TrimSuffix(model, "-think")
+	}
+	for _, pattern := range sc.Models {
+		if pattern == "*" || pattern == model {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(model, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSessionCapabilities 保存检测到的会话能力
+func SetSessionCapabilities(sessionID string, caps SessionCapabilities) {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	capabilities[sessionID] = caps
+}
+
+// GetSessionCapabilities 获取池条目（见 SessionInfo.ID）的能力，尚未检测过的返回 false
+func GetSessionCapabilities(sessionID string) (SessionCapabilities, bool) {
+	capabilitiesMu.RLock()
+	defer capabilitiesMu.RUnlock()
+	caps, ok := capabilities[sessionID]
+	return caps, ok
+}
@@ -18,8 +18,20 @@ import (
 )
 
 type SessionInfo struct {
-	SessionKey string `yaml:"sessionKey" json:"key"`
-	OrgID      string `yaml:"orgID" json:"orgID,omitempty"`
+	SessionKey string   `yaml:"sessionKey" json:"key"`
+	OrgID      string   `yaml:"orgID" json:"orgID,omitempty"`
+	OrgPolicy  string   `yaml:"orgPolicy" json:"orgPolicy,omitempty"`
+	Proxy      string   `yaml:"proxy" json:"proxy,omitempty"`     // 固定使用的代理名称或地址
+	Profile    string   `yaml:"profile" json:"profile,omitempty"` // 使用的客户端配置名称
+	Tags       []string `yaml:"tags" json:"tags,omitempty"`
+}
+
+// ID 返回会话在池中的唯一标识，同一账号的不同组织是不同的池条目
+func (s SessionInfo) ID() string {
+	if s.OrgID == "" {
+		return s.SessionKey
+	}
+	return s.SessionKey + ":" + s.OrgID
 }
 
 type SessionRagen struct {
@@ -29,18 +41,63 @@ type SessionRagen struct {
 }
 
 type Config struct {
-	Sessions               []SessionInfo `yaml:"sessions"`
-	Address                string        `yaml:"address"`
-	APIKey                 string        `yaml:"apiKey"`
-	Proxy                  string        `yaml:"proxy"`
-	ChatDelete             bool          `yaml:"chatDelete"`
-	MaxChatHistoryLength   int           `yaml:"maxChatHistoryLength"`
-	RetryCount             int           `yaml:"retryCount"`
-	NoRolePrefix           bool          `yaml:"noRolePrefix"`
-	PromptDisableArtifacts bool          `yaml:"promptDisableArtifacts"`
-	EnableMirrorApi        bool          `yaml:"enableMirrorApi"`
-	MirrorApiPrefix        string        `yaml:"mirrorApiPrefix"`
-	RwMutx                 sync.RWMutex  `yaml:"-"` // 不从YAML加载
+	Sessions               []SessionInfo            `yaml:"sessions"`
+	Address                string                   `yaml:"address"`
+	APIKey                 string                   `yaml:"apiKey"`
+	Proxy                  string                   `yaml:"proxy"`
+	ChatDelete             bool                     `yaml:"chatDelete"`
+	MaxChatHistoryLength   int                      `yaml:"maxChatHistoryLength"`
+	RetryCount             int                      `yaml:"retryCount"`
+	NoRolePrefix           bool                     `yaml:"noRolePrefix"`
+	PromptDisableArtifacts bool                     `yaml:"promptDisableArtifacts"`
+	EnableMirrorApi        bool                     `yaml:"enableMirrorApi"`
+	MirrorApiPrefix        string                   `yaml:"mirrorApiPrefix"`
+	APIKeys                []APIKeyPolicy           `yaml:"apiKeys"`
+	ModelTags              map[string][]string      `yaml:"modelTags"`
+	PlanModels             map[string][]string      `yaml:"planModels"`
+	PlanLimits             map[string]PlanLimits    `yaml:"planLimits"`
+	OrgPolicy              string                   `yaml:"orgPolicy"`
+	Proxies                []ProxyInfo              `yaml:"proxies"`
+	ProxyCheckInterval     int                      `yaml:"proxyCheckInterval"`
+	ProxyCheckURL          string                   `yaml:"proxyCheckURL"`
+	AdminKey               string                   `yaml:"adminKey"`
+	Profiles               map[string]ClientProfile `yaml:"profiles"`
+	DefaultProfile         string                   `yaml:"defaultProfile"`
+	BlockCooldown          int                      `yaml:"blockCooldown"`
+	DataDir                string                   `yaml:"dataDir"`
+	CleanupMaxAttempts     int                      `yaml:"cleanupMaxAttempts"`
+	SweepInterval          int                      `yaml:"sweepIntervalMinutes"`
+	SweepMaxAge            int                      `yaml:"sweepMaxAgeMinutes"`
+	SweepDryRun            bool                     `yaml:"sweepDryRun"`
+	SweepUntitled          bool                     `yaml:"sweepUntitled"`
+	ConversationReuse      bool                     `yaml:"conversationReuse"`
+	ConversationReuseTTL   int                      `yaml:"conversationReuseTTL"`
+	ImageFetchMaxMB        int                      `yaml:"imageFetchMaxMB"`
+	ImageFetchTimeout      int                      `yaml:"imageFetchTimeout"`
+	ImageFetchAllowPrivate bool                     `yaml:"imageFetchAllowPrivate"`
+	MaxImages              int                      `yaml:"maxImages"`
+	NoImagePreprocess      bool                     `yaml:"noImagePreprocess"`
+	ImageMaxWidth          int                      `yaml:"imageMaxWidth"`
+	ImageMaxHeight         int                      `yaml:"imageMaxHeight"`
+	ImageJPEGQuality       int                      `yaml:"imageJPEGQuality"`
+	ImageMaxPixels         int                      `yaml:"imageMaxPixels"`
+	UploadCacheTTL         int                      `yaml:"uploadCacheTTL"`
+	FilesMaxMB             int                      `yaml:"filesMaxMB"`
+	MaxChatHistoryTokens   int                      `yaml:"maxChatHistoryTokens"`
+	ContextFileTokens      int                      `yaml:"contextFileTokens"`
+	ContextInlineTurns     int                      `yaml:"contextInlineTurns"`
+	ModelContextWindow     int                      `yaml:"modelContextWindow"`
+	ModelContextWindows    map[string]int           `yaml:"modelContextWindows"`
+	ContextStrategy        string                   `yaml:"contextStrategy"`
+	PromptFormat           string                   `yaml:"promptFormat"`
+	PromptFormats          map[string]PromptFormat  `yaml:"promptFormats"`
+	ModelPromptFormats     map[string]string        `yaml:"modelPromptFormats"`
+	JSONRetries            int                      `yaml:"jsonRetries"`
+	NoRoleLeakGuard        bool                     `yaml:"noRoleLeakGuard"`
+	NoPrefill              bool                     `yaml:"noPrefill"`
+	MaxChoices             int                      `yaml:"maxChoices"`
+	CapabilityRefresh      int                      `yaml:"capabilityRefreshMinutes"`
+	RwMutx                 sync.RWMutex             `yaml:"-"` // 不从YAML加载
 }
 
 // 解析 SESSION 格式的环境变量
@@ -89,17 +146,51 @@ func (c *Config) GetSessionForModel(idx int) (SessionInfo, error) {
 	return c.Sessions[validIdx], nil
 }
 
+// MaskSessionKey 只保留密钥前后各10个字符，用于日志输出
+func MaskSessionKey(sessionKey string) string {
+	if len(sessionKey) > 20 {
+		return sessionKey[:10] + "***" + sessionKey[len(sessionKey)-10:]
+	}
+	return sessionKey
+}
+
 func (c *Config) SetSessionOrgID(sessionKey, orgID string) {
+	c.ExpandSessionOrgs(sessionKey, []string{orgID})
+}
+
+// ExpandSessionOrgs 为尚未确定组织的会话设置组织，多个组织时展开为多个池条目
+func (c *Config) ExpandSessionOrgs(sessionKey string, orgIDs []string) {
+	if len(orgIDs) == 0 {
+		return
+	}
 	c.RwMutx.Lock()
 	defer c.RwMutx.Unlock()
 	for i, session := range c.Sessions {
-		if session.SessionKey == sessionKey {
-			logger.Info(fmt.Sprintf("Setting OrgID for session %s to %s", sessionKey, orgID))
-			c.Sessions[i].OrgID = orgID
-			return
+		if session.SessionKey != sessionKey || session.OrgID != "" {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Setting OrgID for session %s to %s", MaskSessionKey(sessionKey), strings.Join(orgIDs, ", ")))
+		entries := make([]SessionInfo, 0, len(orgIDs))
+		for _, orgID := range orgIDs {
+			entry := session
+			entry.OrgID = orgID
+			entries = append(entries, entry)
 		}
+		sessions := append([]SessionInfo{}, c.Sessions[:i]...)
+		sessions = append(sessions, entries...)
+		c.Sessions = append(sessions, c.Sessions[i+1:]...)
+		return
+	}
+}
+
+// OrgPolicyFor 返回会话使用的组织选择策略，未单独配置时使用全局策略
+func (c *Config) OrgPolicyFor(session SessionInfo) string {
+	if session.OrgPolicy != "" {
+		return session.OrgPolicy
 	}
+	return c.OrgPolicy
 }
+
 func (sr *SessionRagen) NextIndex() int {
 	sr.Mutex.Lock()
 	defer sr.Mutex.Unlock()
@@ -110,8 +201,8 @@ func (sr *SessionRagen) NextIndex() int {
 	return index
 }
 
-// 获取下一个会话，并处理重试逻辑
-func (sr *SessionRagen) GetNextSessionWithRetry() (SessionInfo, error) {
+// 获取下一个满足选择器的会话，并处理重试逻辑
+func (sr *SessionRagen) GetNextSessionWithRetry(sel SessionSelector) (SessionInfo, error) {
 	sr.Mutex.Lock()
 	defer sr.Mutex.Unlock()
 
@@ -120,20 +211,58 @@ func (sr *SessionRagen) GetNextSessionWithRetry() (SessionInfo, error) {
 		return SessionInfo{}, fmt.Errorf("exceeded maximum retry count (%d)", ConfigInstance.RetryCount)
 	}
 
-	// 获取当前索引
-	index := sr.Index
-	// 移动到下一个索引（轮询）
-	sr.Index = (index + 1) % len(ConfigInstance.Sessions)
+	session, err := sr.nextSession(sel)
+	if err != nil {
+		return SessionInfo{}, err
+	}
 	// 增加重试计数
 	sr.RetryCount++
+	return session, nil
+}
+
+// NextSession 轮询获取下一个满足选择器的会话，不计入重试次数，由调用方控制尝试次数
+func (sr *SessionRagen) NextSession(sel SessionSelector) (SessionInfo, error) {
+	sr.Mutex.Lock()
+	defer sr.Mutex.Unlock()
+	return sr.nextSession(sel)
+}
+
+// nextSession 从当前位置轮询满足选择器的会话，调用方需持有 sr.Mutex
+func (sr *SessionRagen) nextSession(sel SessionSelector) (SessionInfo, error) {
+	ConfigInstance.RwMutx.RLock()
+	total := len(ConfigInstance.Sessions)
+	index := -1
+	var session SessionInfo
+	var rejections []string
+	// 从当前位置开始轮询，跳过不满足条件的会话
+	for i := 0; i < total; i++ {
+		candidate := (sr.Index + i) % total
+		reason := sel.Rejection(ConfigInstance.Sessions[candidate])
+		if reason == "" {
+			index = candidate
+			session = ConfigInstance.Sessions[candidate]
+			break
+		}
+		rejections = append(rejections, reason)
+	}
+	ConfigInstance.RwMutx.RUnlock()
+
+	if total == 0 {
+		return SessionInfo{}, fmt.Errorf("no available sessions")
+	}
+	if index < 0 {
+		return SessionInfo{}, fmt.Errorf("%w for %s: %s", ErrNoEligibleSession, sel, rejectionSummary(rejections))
+	}
+
+	// 移动到下一个索引（轮询）
+	sr.Index = (index + 1) % total
 
 	// 如果已经尝试了所有会话一轮，记录日志
 	if sr.Index == 0 {
 		logger.Info("Completed one full rotation of all session keys, starting again from the beginning")
 	}
 
-	// 获取会话
-	return ConfigInstance.GetSessionForModel(index)
+	return session, nil
 }
 
 // 重置重试计数器
@@ -188,10 +317,84 @@ func loadConfigFromYAML(configPath string) (*Config, error) {
 	if config.Address == "" {
 		config.Address = "0.0.0.0:8080"
 	}
+	if config.CapabilityRefresh == 0 {
+		config.CapabilityRefresh = 30
+	}
+	if config.ProxyCheckInterval == 0 {
+		config.ProxyCheckInterval = 60
+	}
+	if config.BlockCooldown <= 0 {
+		config.BlockCooldown = 600
+	}
+	if config.CleanupMaxAttempts <= 0 {
+		config.CleanupMaxAttempts = 20
+	}
+	if config.SweepMaxAge <= 0 {
+		config.SweepMaxAge = 60
+	}
+	if config.ConversationReuseTTL <= 0 {
+		config.ConversationReuseTTL = 30
+	}
+	if config.ImageFetchMaxMB <= 0 {
+		config.ImageFetchMaxMB = 10
+	}
+	if config.ImageFetchTimeout <= 0 {
+		config.ImageFetchTimeout = 15
+	}
+	if config.MaxImages <= 0 {
+		config.MaxImages = 20
+	}
+	if config.ImageMaxWidth <= 0 {
+		config.ImageMaxWidth = 1568
+	}
+	if config.ImageMaxHeight <= 0 {
+		config.ImageMaxHeight = 1568
+	}
+	if config.ImageJPEGQuality <= 0 || config.ImageJPEGQuality > 100 {
+		config.ImageJPEGQuality = 85
+	}
+	if config.ImageMaxPixels <= 0 {
+		config.ImageMaxPixels = 25_000_000
+	}
+	if config.UploadCacheTTL == 0 {
+		config.UploadCacheTTL = 60
+	}
+	if config.FilesMaxMB <= 0 {
+		config.FilesMaxMB = 32
+	}
+	if config.MaxChatHistoryTokens <= 0 {
+		config.MaxChatHistoryTokens = defaultHistoryTokens(config.MaxChatHistoryLength)
+	}
+	if config.ContextFileTokens <= 0 {
+		config.ContextFileTokens = 30000
+	}
+	if config.ContextInlineTurns <= 0 {
+		config.ContextInlineTurns = 1
+	}
+	if config.ModelContextWindow <= 0 {
+		config.ModelContextWindow = 200000
+	}
+	if config.JSONRetries == 0 {
+		config.JSONRetries = 2
+	}
+	if config.MaxChoices <= 0 {
+		config.MaxChoices = 4
+	}
+	if config.ProxyCheckURL == "" {
+		config.ProxyCheckURL = "https://claude.ai/favicon.ico"
+	}
 
 	return &config, nil
 }
 
+// defaultHistoryTokens 未设置 maxChatHistoryTokens 时，按旧的 maxChatHistoryLength（字符数）约 4 字符 1 token 换算
+func defaultHistoryTokens(maxChatHistoryLength int) int {
+	if maxChatHistoryLength > 0 {
+		return (maxChatHistoryLength + 3) / 4
+	}
+	return 2500
+}
+
 // 找到sessionKeys.json文件的路径
 func findSessionKeysFile() (string, error) {
 	// 获取可执行文件所在目录
@@ -242,8 +445,12 @@ func loadSessionKeysFromJSON() ([]SessionInfo, error) {
 
 	// 解析JSON
 	type SessionKeyEntry struct {
-		ID  int    `json:"id"`
-		Key string `json:"key"`
+		ID        int      `json:"id"`
+		Key       string   `json:"key"`
+		OrgPolicy string   `json:"orgPolicy,omitempty"`
+		Proxy     string   `json:"proxy,omitempty"`
+		Profile   string   `json:"profile,omitempty"`
+		Tags      []string `json:"tags,omitempty"`
 	}
 
 	type SessionKeysFile struct {
@@ -262,6 +469,10 @@ func loadSessionKeysFromJSON() ([]SessionInfo, error) {
 		sessions = append(sessions, SessionInfo{
 			SessionKey: entry.Key,
 			OrgID:      "", // 默认为空
+			OrgPolicy:  entry.OrgPolicy,
+			Proxy:      entry.Proxy,
+			Profile:    entry.Profile,
+			Tags:       entry.Tags,
 		})
 	}
 
@@ -371,6 +582,9 @@ func reloadSessionKeys() {
 	Sr.Mutex.Unlock()
 
 	logger.Info(fmt.Sprintf("Successfully reloaded %d session keys (previous count: %d)", len(sessions), oldSessionCount))
+	if OnSessionsReloaded != nil {
+		go OnSessionsReloaded()
+	}
 
 	// 打印新加载的会话密钥信息（带掩码）
 	for i, session := range sessions {
@@ -390,6 +604,105 @@ func loadConfigFromEnv() *Config {
 	if err != nil {
 		maxChatHistoryLength = 10000 // 默认值
 	}
+	capabilityRefresh, err := strconv.Atoi(os.Getenv("CAPABILITY_REFRESH_MINUTES"))
+	if err != nil {
+		capabilityRefresh = 30 // 默认每 30 分钟刷新一次
+	}
+	proxyCheckInterval, err := strconv.Atoi(os.Getenv("PROXY_CHECK_INTERVAL"))
+	if err != nil {
+		proxyCheckInterval = 60 // 默认每 60 秒检查一次代理
+	}
+	blockCooldown, err := strconv.Atoi(os.Getenv("BLOCK_COOLDOWN"))
+	if err != nil || blockCooldown <= 0 {
+		blockCooldown = 600 // 默认冷却 10 分钟
+	}
+	cleanupMaxAttempts, err := strconv.Atoi(os.Getenv("CLEANUP_MAX_ATTEMPTS"))
+	if err != nil || cleanupMaxAttempts <= 0 {
+		cleanupMaxAttempts = 20
+	}
+	sweepInterval, err := strconv.Atoi(os.Getenv("SWEEP_INTERVAL_MINUTES"))
+	if err != nil {
+		sweepInterval = 0 // 默认不清扫
+	}
+	sweepMaxAge, err := strconv.Atoi(os.Getenv("SWEEP_MAX_AGE_MINUTES"))
+	if err != nil || sweepMaxAge <= 0 {
+		sweepMaxAge = 60
+	}
+	conversationReuseTTL, err := strconv.Atoi(os.Getenv("CONVERSATION_REUSE_TTL"))
+	if err != nil || conversationReuseTTL <= 0 {
+		conversationReuseTTL = 30 // 默认保留 30 分钟
+	}
+	imageFetchMaxMB, err := strconv.Atoi(os.Getenv("IMAGE_FETCH_MAX_MB"))
+	if err != nil || imageFetchMaxMB <= 0 {
+		imageFetchMaxMB = 10
+	}
+	imageFetchTimeout, err := strconv.Atoi(os.Getenv("IMAGE_FETCH_TIMEOUT"))
+	if err != nil || imageFetchTimeout <= 0 {
+		imageFetchTimeout = 15
+	}
+	maxImages, err := strconv.Atoi(os.Getenv("MAX_IMAGES"))
+	if err != nil || maxImages <= 0 {
+		maxImages = 20
+	}
+	imageMaxWidth, err := strconv.Atoi(os.Getenv("IMAGE_MAX_WIDTH"))
+	if err != nil || imageMaxWidth <= 0 {
+		imageMaxWidth = 1568
+	}
+	imageMaxHeight, err := strconv.Atoi(os.Getenv("IMAGE_MAX_HEIGHT"))
+	if err != nil || imageMaxHeight <= 0 {
+		imageMaxHeight = 1568
+	}
+	imageJPEGQuality, err := strconv.Atoi(os.Getenv("IMAGE_JPEG_QUALITY"))
+	if err != nil || imageJPEGQuality <= 0 || imageJPEGQuality > 100 {
+		imageJPEGQuality = 85
+	}
+	imageMaxPixels, err := strconv.Atoi(os.Getenv("IMAGE_MAX_PIXELS"))
+	if err != nil || imageMaxPixels <= 0 {
+		imageMaxPixels = 25_000_000 // 解码后约 100MB
+	}
+	uploadCacheTTL, err := strconv.Atoi(os.Getenv("UPLOAD_CACHE_TTL"))
+	if err != nil || uploadCacheTTL == 0 {
+		uploadCacheTTL = 60 // 默认缓存 60 分钟，负数关闭
+	}
+	filesMaxMB, err := strconv.Atoi(os.Getenv("FILES_MAX_MB"))
+	if err != nil || filesMaxMB <= 0 {
+		filesMaxMB = 32
+	}
+	maxChatHistoryTokens, err := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_TOKENS"))
+	if err != nil || maxChatHistoryTokens <= 0 {
+		maxChatHistoryTokens = defaultHistoryTokens(maxChatHistoryLength)
+	}
+	contextFileTokens, err := strconv.Atoi(os.Getenv("CONTEXT_FILE_TOKENS"))
+	if err != nil || contextFileTokens <= 0 {
+		contextFileTokens = 30000
+	}
+	contextInlineTurns, err := strconv.Atoi(os.Getenv("CONTEXT_INLINE_TURNS"))
+	if err != nil || contextInlineTurns <= 0 {
+		contextInlineTurns = 1
+	}
+	modelContextWindow, err := strconv.Atoi(os.Getenv("MODEL_CONTEXT_WINDOW"))
+	if err != nil || modelContextWindow <= 0 {
+		modelContextWindow = 200000
+	}
+	jsonRetries, err := strconv.Atoi(os.Getenv("JSON_RETRIES"))
+	if err != nil || jsonRetries == 0 {
+		jsonRetries = 2 // 默认重试 2 次，负数不重试
+	}
+	maxChoices, err := strconv.Atoi(os.Getenv("MAX_CHOICES"))
+	if err != nil || maxChoices <= 0 {
+		maxChoices = 4
+	}
+	proxyCheckURL := os.Getenv("PROXY_CHECK_URL")
+	if proxyCheckURL == "" {
+		proxyCheckURL = "https://claude.ai/favicon.ico"
+	}
+	// PROXIES 为逗号分隔的代理地址列表
+	var proxies []ProxyInfo
+	for _, proxyURL := range strings.Split(os.Getenv("PROXIES"), ",") {
+		if proxyURL = strings.TrimSpace(proxyURL); proxyURL != "" {
+			proxies = append(proxies, ProxyInfo{URL: proxyURL})
+		}
+	}
 
 	// 获取SESSIONS环境变量
 	sessionsEnv := os.Getenv("SESSIONS")
@@ -442,6 +755,66 @@ func loadConfigFromEnv() *Config {
 		EnableMirrorApi: os.Getenv("ENABLE_MIRROR_API") == "true",
 		// 设置镜像API前缀
 		MirrorApiPrefix: os.Getenv("MIRROR_API_PREFIX"),
+		// 设置多组织账号的组织选择策略
+		OrgPolicy: os.Getenv("ORG_POLICY"),
+		// 设置账号能力刷新间隔（分钟）
+		CapabilityRefresh: capabilityRefresh,
+		// 设置代理池及健康检查
+		Proxies:            proxies,
+		ProxyCheckInterval: proxyCheckInterval,
+		ProxyCheckURL:      proxyCheckURL,
+		// 设置管理接口密钥，为空时使用 APIKEY
+		AdminKey: os.Getenv("ADMIN_KEY"),
+		// 设置默认客户端配置（chrome、firefox、safari）
+		DefaultProfile: os.Getenv("DEFAULT_PROFILE"),
+		// 设置被拦截的会话或代理的冷却时间（秒）
+		BlockCooldown: blockCooldown,
+		// 设置运行时数据目录，为空时使用 sessionKeys.json 所在目录
+		DataDir: os.Getenv("DATA_DIR"),
+		// 设置删除对话的最大尝试次数
+		CleanupMaxAttempts: cleanupMaxAttempts,
+		// 设置遗留对话清扫：间隔（分钟，0 为关闭）、最小闲置时间（分钟）、仅报告模式、是否包含无标题对话
+		SweepInterval: sweepInterval,
+		SweepMaxAge:   sweepMaxAge,
+		SweepDryRun:   os.Getenv("SWEEP_DRY_RUN") == "true",
+		SweepUntitled: os.Getenv("SWEEP_UNTITLED") == "true",
+		// 设置是否复用上游对话，只发送新增的用户消息，以及对话的保留时间（分钟）
+		ConversationReuse:    os.Getenv("CONVERSATION_REUSE") == "true",
+		ConversationReuseTTL: conversationReuseTTL,
+		// 设置远程图片下载的大小上限（MB）、超时（秒）以及是否允许访问内网地址
+		ImageFetchMaxMB:        imageFetchMaxMB,
+		ImageFetchTimeout:      imageFetchTimeout,
+		ImageFetchAllowPrivate: os.Getenv("IMAGE_FETCH_ALLOW_PRIVATE") == "true",
+		// 设置每个请求最多的图片数量
+		MaxImages: maxImages,
+		// 设置上传前的图片处理：是否关闭、最大宽高（像素）和 JPEG 压缩质量
+		NoImagePreprocess: os.Getenv("NO_IMAGE_PREPROCESS") == "true",
+		ImageMaxWidth:     imageMaxWidth,
+		ImageMaxHeight:    imageMaxHeight,
+		ImageJPEGQuality:  imageJPEGQuality,
+		// 设置解码前允许的最大像素数
+		ImageMaxPixels: imageMaxPixels,
+		// 设置已上传文件的缓存时间（分钟），负数关闭
+		UploadCacheTTL: uploadCacheTTL,
+		// 设置通过 /v1/files 上传的文件大小上限（MB）
+		FilesMaxMB: filesMaxMB,
+		// 设置上下文处理（按估算的 token 数）：转为附件的阈值、每个附件的大小、保留在提示词中的最近消息数和模型上下文上限
+		MaxChatHistoryTokens: maxChatHistoryTokens,
+		ContextFileTokens:    contextFileTokens,
+		ContextInlineTurns:   contextInlineTurns,
+		ModelContextWindow:   modelContextWindow,
+		// 设置超出模型上下文上限时的截断策略：none、drop_oldest、middle_out、summarize
+		ContextStrategy: os.Getenv("CONTEXT_STRATEGY"),
+		// 设置提示词格式：role_prefix、plain、xml，或 YAML 中 promptFormats 定义的格式
+		PromptFormat: os.Getenv("PROMPT_FORMAT"),
+		// 设置 JSON 输出不符合 response_format 时的重试次数，负数不重试
+		JSONRetries: jsonRetries,
+		// 设置是否不在回复中出现其他角色的前缀时截断
+		NoRoleLeakGuard: os.Getenv("NO_ROLE_LEAK_GUARD") == "true",
+		// 设置是否不把最后一条助手消息作为回复的开头
+		NoPrefill: os.Getenv("NO_PREFILL") == "true",
+		// 设置每个请求最多的选项数量（n）
+		MaxChoices: maxChoices,
 		// 设置读写锁
 		RwMutx: sync.RWMutex{},
 	}
@@ -474,6 +847,10 @@ func LoadConfig() *Config {
 
 var ConfigInstance *Config
 var Sr *SessionRagen
+var Proxies *ProxyPool
+
+// OnSessionsReloaded 在 sessionKeys.json 重新加载后调用，用于重新检测组织和能力
+var OnSessionsReloaded func()
 var watcher *fsnotify.Watcher
 var sessionKeysFilePath string
 
@@ -487,6 +864,7 @@ func init() {
 		Mutex:      sync.Mutex{},
 	}
 	ConfigInstance = LoadConfig()
+	Proxies = NewProxyPool(ConfigInstance.Proxies)
 
 	// 设置文件监听器来实时监控sessionKeys.json文件的变化
 	if len(ConfigInstance.Sessions) > 0 && os.Getenv("SESSIONS") == "" {
@@ -509,15 +887,54 @@ func init() {
 		if sessionKeyLength > 20 {
 			maskedKey = session.SessionKey[:10] + "***" + session.SessionKey[sessionKeyLength-10:]
 		}
-		logger.Info(fmt.Sprintf("Session %d: %s, OrgID: %s", i+1, maskedKey, session.OrgID))
+		logger.Info(fmt.Sprintf("Session %d: %s, OrgID: %s, Tags: %v", i+1, maskedKey, session.OrgID, session.Tags))
 	}
 	logger.Info(fmt.Sprintf("Address: %s", ConfigInstance.Address))
 	logger.Info(fmt.Sprintf("APIKey: %s", ConfigInstance.APIKey))
 	logger.Info(fmt.Sprintf("Proxy: %s", ConfigInstance.Proxy))
+	for _, p := range Proxies.Status() {
+		logger.Info(fmt.Sprintf("Proxy pool: %s (%s)", p.Name, p.URL))
+	}
+	logger.Info(fmt.Sprintf("ProxyCheckInterval: %ds, ProxyCheckURL: %s", ConfigInstance.ProxyCheckInterval, ConfigInstance.ProxyCheckURL))
+	logger.Info(fmt.Sprintf("BlockCooldown: %ds", ConfigInstance.BlockCooldown))
+	logger.Info(fmt.Sprintf("DataDir: %s", DataDir()))
+	logger.Info(fmt.Sprintf("CleanupMaxAttempts: %d", ConfigInstance.CleanupMaxAttempts))
+	logger.Info(fmt.Sprintf("SweepIntervalMinutes: %d, SweepMaxAgeMinutes: %d, SweepDryRun: %t, SweepUntitled: %t",
+		ConfigInstance.SweepInterval, ConfigInstance.SweepMaxAge, ConfigInstance.SweepDryRun, ConfigInstance.SweepUntitled))
 	logger.Info(fmt.Sprintf("ChatDelete: %t", ConfigInstance.ChatDelete))
-	logger.Info(fmt.Sprintf("MaxChatHistoryLength: %d", ConfigInstance.MaxChatHistoryLength))
+	logger.Info(fmt.Sprintf("ImageFetchMaxMB: %d, ImageFetchTimeout: %ds, ImageFetchAllowPrivate: %t, MaxImages: %d",
+		ConfigInstance.ImageFetchMaxMB, ConfigInstance.ImageFetchTimeout, ConfigInstance.ImageFetchAllowPrivate, ConfigInstance.MaxImages))
+	logger.Info(fmt.Sprintf("NoImagePreprocess: %t, ImageMaxSize: %dx%d, ImageJPEGQuality: %d, ImageMaxPixels: %d",
+		ConfigInstance.NoImagePreprocess, ConfigInstance.ImageMaxWidth, ConfigInstance.ImageMaxHeight, ConfigInstance.ImageJPEGQuality, ConfigInstance.ImageMaxPixels))
+	logger.Info(fmt.Sprintf("UploadCacheTTL: %dm", ConfigInstance.UploadCacheTTL))
+	logger.Info(fmt.Sprintf("FilesMaxMB: %d", ConfigInstance.FilesMaxMB))
+	logger.Info(fmt.Sprintf("ConversationReuse: %t, ConversationReuseTTL: %dm", ConfigInstance.ConversationReuse, ConfigInstance.ConversationReuseTTL))
+	logger.Info(fmt.Sprintf("MaxChatHistoryTokens: %d, ContextFileTokens: %d, ContextInlineTurns: %d, ModelContextWindow: %d",
+		ConfigInstance.MaxChatHistoryTokens, ConfigInstance.ContextFileTokens, ConfigInstance.ContextInlineTurns, ConfigInstance.ModelContextWindow))
+	logger.Info(fmt.Sprintf("ContextStrategy: %s", ConfigInstance.ContextStrategy))
+	logger.Info(fmt.Sprintf("JSONRetries: %d", ConfigInstance.JSONRetries))
+	logger.Info(fmt.Sprintf("MaxChoices: %d", ConfigInstance.MaxChoices))
+	logger.Info(fmt.Sprintf("PromptFormat: %s, custom formats: %d", ConfigInstance.PromptFormat, len(ConfigInstance.PromptFormats)))
+	for model, format := range ConfigInstance.ModelPromptFormats {
+		logger.Info(fmt.Sprintf("Model %s prompt format: %s", model, format))
+	}
+	for model, window := range ConfigInstance.ModelContextWindows {
+		logger.Info(fmt.Sprintf("Model %s context window: %d", model, window))
+	}
 	logger.Info(fmt.Sprintf("NoRolePrefix: %t", ConfigInstance.NoRolePrefix))
+	logger.Info(fmt.Sprintf("NoRoleLeakGuard: %t", ConfigInstance.NoRoleLeakGuard))
+	logger.Info(fmt.Sprintf("NoPrefill: %t", ConfigInstance.NoPrefill))
 	logger.Info(fmt.Sprintf("PromptDisableArtifacts: %t", ConfigInstance.PromptDisableArtifacts))
 	logger.Info(fmt.Sprintf("EnableMirrorApi: %t", ConfigInstance.EnableMirrorApi))
 	logger.Info(fmt.Sprintf("MirrorApiPrefix: %s", ConfigInstance.MirrorApiPrefix))
+	logger.Info(fmt.Sprintf("CapabilityRefreshMinutes: %d", ConfigInstance.CapabilityRefresh))
+	logger.Info(fmt.Sprintf("OrgPolicy: %s", ConfigInstance.OrgPolicy))
+	logger.Info(fmt.Sprintf("DefaultProfile: %s, Profiles: %d", ConfigInstance.DefaultProfile, len(ConfigInstance.Profiles)))
+	logger.Info(fmt.Sprintf("API key policies: %d", len(ConfigInstance.APIKeys)))
+	for model, tags := range ConfigInstance.ModelTags {
+		logger.Info(fmt.Sprintf("Model %s routed to tags: %v", model, tags))
+	}
+	for plan, limits := range ConfigInstance.PlanLimits {
+		logger.Info(fmt.Sprintf("Plan %s limits: %s", plan, limits))
+	}
 }
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DataDir 返回保存运行时数据（清理队列等）的目录：
+// 优先使用 dataDir 配置，其次是 sessionKeys.json 所在目录，最后是工作目录下的 data
+func DataDir() string {
+	if ConfigInstance != nil && ConfigInstance.DataDir != "" {
+		return ConfigInstance.DataDir
+	}
+	if path, err := findSessionKeysFile(); err == nil {
+		return filepath.Dir(path)
+	}
+	if _, err := os.Stat("/app/data"); err == nil {
+		return "/app/data"
+	}
+	workDir, _ := os.Getwd()
+	return filepath.Join(workDir, "data")
+}
+
+// LoadDataFile 从数据目录读取 JSON 文件，文件不存在时不修改 v
+func LoadDataFile(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(DataDir(), name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return nil
+}
+
+// SaveDataFile 将 v 以 JSON 写入数据目录，先写临时文件再重命名，避免写入中断导致文件损坏。
+// 文件中可能包含会话密钥，因此只允许当前用户读写
+func SaveDataFile(name string, v interface{}) error {
+	dir := DataDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", name, err)
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"claude2api/logger"
+	"fmt"
+)
+
+// ClientProfile 上游请求使用的浏览器指纹和请求头配置
+type ClientProfile struct {
+	Impersonate    string            `yaml:"impersonate" json:"impersonate"` // chrome、firefox 或 safari
+	UserAgent      string            `yaml:"userAgent" json:"userAgent,omitempty"`
+	AcceptLanguage string            `yaml:"acceptLanguage" json:"acceptLanguage,omitempty"`
+	Timezone       string            `yaml:"timezone" json:"timezone,omitempty"`
+	Cookies        map[string]string `yaml:"cookies" json:"-"` // 额外的 cookie，例如 cf_clearance
+}
+
+// 内置的配置，可直接通过名称引用
+var builtinProfiles = map[string]ClientProfile{
+	"chrome":  {Impersonate: "chrome"},
+	"firefox": {Impersonate: "firefox"},
+	"safari":  {Impersonate: "safari"},
+}
+
+// ProfileFor 返回会话使用的客户端配置，未单独配置时使用 defaultProfile
+func (c *Config) ProfileFor(session SessionInfo) ClientProfile {
+	name := session.Profile
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return ClientProfile{}
+	}
+	c.RwMutx.RLock()
+	profile, ok := c.Profiles[name]
+	c.RwMutx.RUnlock()
+	if ok {
+		return profile
+	}
+	if profile, ok := builtinProfiles[name]; ok {
+		return profile
+	}
+	logger.Warn(fmt.Sprintf("Unknown client profile %s, using default", name))
+	return ClientProfile{}
+}
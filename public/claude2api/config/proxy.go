@@ -0,0 +1,347 @@
+package config
+
+import (
+	"claude2api/logger"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 请求连续失败多少次后将代理标记为不可用
+const proxyFailureThreshold = 3
+
+// ProxyInfo 代理池中的一个出口代理，支持 http、https、socks5（可带用户名密码）
+type ProxyInfo struct {
+	Name string `yaml:"name" json:"name"`
+	URL  string `yaml:"url" json:"url"`
+}
+
+// ProxyStatus 代理的健康状态
+type ProxyStatus struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"lastCheck,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	Sessions  int       `json:"sessions"`
+	// 被上游拦截后的冷却状态
+	BlockedUntil time.Time `json:"blockedUntil,omitempty"`
+	BlockReason  string    `json:"blockReason,omitempty"`
+}
+
+type proxyEntry struct {
+	info         ProxyInfo
+	healthy      bool
+	failures     int
+	lastCheck    time.Time
+	lastError    string
+	blockedUntil time.Time
+	blockReason  string
+}
+
+// usable 判断代理是否健康且不在拦截冷却期内
+func (e *proxyEntry) usable(now time.Time) bool {
+	return e.healthy && !now.Before(e.blockedUntil)
+}
+
+// ProxyPool 管理代理列表、健康状态以及会话与代理的绑定关系
+type ProxyPool struct {
+	mu          sync.Mutex
+	proxies     []*proxyEntry
+	assignments map[string]string // 会话 ID -> 代理名称
+}
+
+// NewProxyPool 根据配置创建代理池，新代理在首次检查前视为可用
+func NewProxyPool(proxies []ProxyInfo) *ProxyPool {
+	pool := &ProxyPool{assignments: map[string]string{}}
+	for _, p := range proxies {
+		if err := pool.Add(p); err != nil {
+			logger.Error(fmt.Sprintf("Ignoring proxy %s: %v", p.Name, err))
+		}
+	}
+	return pool
+}
+
+// ValidateProxyURL 检查代理地址的协议是否受支持
+func ValidateProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy url has no host")
+	}
+	return nil
+}
+
+// RedactProxyURL 隐藏代理地址中的密码，用于日志和管理接口
+func RedactProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return proxyURL
+	}
+	return u.Redacted()
+}
+
+// Add 向代理池添加代理，名称为空时使用 host:port 作为名称
+func (p *ProxyPool) Add(info ProxyInfo) error {
+	if err := ValidateProxyURL(info.URL); err != nil {
+		return err
+	}
+	if info.Name == "" {
+		u, _ := url.Parse(info.URL)
+		info.Name = u.Host
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(info.Name) != nil {
+		return fmt.Errorf("proxy %s already exists", info.Name)
+	}
+	p.proxies = append(p.proxies, &proxyEntry{info: info, healthy: true})
+	return nil
+}
+
+// Remove 删除代理，绑定到该代理的会话会在下次请求时重新分配
+func (p *ProxyPool) Remove(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, entry := range p.proxies {
+		if entry.info.Name == name {
+			p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+			p.unassign(name)
+			return true
+		}
+	}
+	return false
+}
+
+// List 返回所有代理（含密码）用于健康检查
+func (p *ProxyPool) List() []ProxyInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]ProxyInfo, 0, len(p.proxies))
+	for _, entry := range p.proxies {
+		list = append(list, entry.info)
+	}
+	return list
+}
+
+// Status 返回所有代理的健康状态，地址中的密码已隐藏
+func (p *ProxyPool) Status() []ProxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	load := p.load()
+	status := make([]ProxyStatus, 0, len(p.proxies))
+	now := time.Now()
+	for _, entry := range p.proxies {
+		st := ProxyStatus{
+			Name:      entry.info.Name,
+			URL:       RedactProxyURL(entry.info.URL),
+			Healthy:   entry.healthy,
+			Failures:  entry.failures,
+			LastCheck: entry.lastCheck,
+			LastError: entry.lastError,
+			Sessions:  load[entry.info.Name],
+		}
+		if now.Before(entry.blockedUntil) {
+			st.BlockedUntil = entry.blockedUntil
+			st.BlockReason = entry.blockReason
+		}
+		status = append(status, st)
+	}
+	return status
+}
+
+// Assignments 返回会话 ID 到代理名称的绑定关系
+func (p *ProxyPool) Assignments() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assignments := make(map[string]string, len(p.assignments))
+	for id, name := range p.assignments {
+		assignments[id] = name
+	}
+	return assignments
+}
+
+// Assign 将会话固定到指定代理
+func (p *ProxyPool) Assign(sessionID, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(name) == nil {
+		return fmt.Errorf("proxy %s not found", name)
+	}
+	p.assignments[sessionID] = name
+	return nil
+}
+
+// ProxyFor 返回会话应使用的代理地址。
+// 已绑定且可用的代理保持不变；否则优先使用会话配置的代理，再选择负载最低的可用代理。
+// 代理池为空时使用全局 proxy 配置。
+func (p *ProxyPool) ProxyFor(session SessionInfo) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.proxies) == 0 {
+		if session.Proxy != "" && ValidateProxyURL(session.Proxy) == nil {
+			return session.Proxy
+		}
+		return ConfigInstance.Proxy
+	}
+
+	id := session.ID()
+	now := time.Now()
+	if name, ok := p.assignments[id]; ok {
+		if entry := p.find(name); entry != nil && entry.usable(now) {
+			return entry.info.URL
+		}
+	}
+
+	var chosen *proxyEntry
+	if entry := p.find(session.Proxy); entry != nil && entry.usable(now) {
+		chosen = entry
+	} else {
+		load := p.load()
+		for _, entry := range p.proxies {
+			if !entry.usable(now) {
+				continue
+			}
+			if chosen == nil || load[entry.info.Name] < load[chosen.info.Name] {
+				chosen = entry
+			}
+		}
+	}
+	if chosen == nil {
+		// 所有代理都不可用时继续使用原绑定，等待健康检查恢复
+		if entry := p.find(p.assignments[id]); entry != nil {
+			return entry.info.URL
+		}
+		chosen = p.proxies[0]
+	}
+
+	if previous, ok := p.assignments[id]; ok && previous != chosen.info.Name {
+		logger.Info(fmt.Sprintf("Session %s reassigned from proxy %s to %s", MaskSessionKey(session.SessionKey), previous, chosen.info.Name))
+	}
+	p.assignments[id] = chosen.info.Name
+	return chosen.info.URL
+}
+
+// DirectEgress 代理池为空时返回会话使用的出口（代理地址，直连为空字符串）；使用代理池时返回 false
+func (p *ProxyPool) DirectEgress(session SessionInfo) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.proxies) > 0 {
+		return "", false
+	}
+	if session.Proxy != "" && ValidateProxyURL(session.Proxy) == nil {
+		return session.Proxy, true
+	}
+	return ConfigInstance.Proxy, true
+}
+
+// ReportResult 根据健康检查结果更新代理状态
+func (p *ProxyPool) ReportResult(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := p.find(name)
+	if entry == nil {
+		return
+	}
+	entry.lastCheck = time.Now()
+	if err != nil {
+		if entry.healthy {
+			logger.Error(fmt.Sprintf("Proxy %s is unhealthy: %v", name, err))
+		}
+		entry.healthy = false
+		entry.failures++
+		entry.lastError = err.Error()
+		return
+	}
+	if !entry.healthy {
+		logger.Info(fmt.Sprintf("Proxy %s is healthy again", name))
+	}
+	entry.healthy = true
+	entry.failures = 0
+	entry.lastError = ""
+}
+
+// RecordFailure 记录通过该代理发出的请求出现网络错误，连续失败达到阈值后标记为不可用
+func (p *ProxyPool) RecordFailure(proxyURL string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.proxies {
+		if entry.info.URL != proxyURL {
+			continue
+		}
+		entry.failures++
+		entry.lastError = err.Error()
+		if entry.healthy && entry.failures >= proxyFailureThreshold {
+			entry.healthy = false
+			logger.Error(fmt.Sprintf("Proxy %s marked unhealthy after %d failures: %v", entry.info.Name, entry.failures, err))
+		}
+		return
+	}
+}
+
+// Block 将代理标记为被上游拦截，冷却期间不再分配给会话；代理不在池中时返回 false
+func (p *ProxyPool) Block(proxyURL, reason string, cooldown time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.proxies {
+		if entry.info.URL != proxyURL {
+			continue
+		}
+		entry.blockedUntil = time.Now().Add(cooldown)
+		entry.blockReason = reason
+		logger.Warn(fmt.Sprintf("Proxy %s blocked for %s: %s", entry.info.Name, cooldown, reason))
+		return true
+	}
+	return false
+}
+
+// RecordSuccess 请求成功后清除代理的连续失败计数
+func (p *ProxyPool) RecordSuccess(proxyURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.proxies {
+		if entry.info.URL == proxyURL && entry.healthy {
+			entry.failures = 0
+			return
+		}
+	}
+}
+
+func (p *ProxyPool) find(name string) *proxyEntry {
+	if name == "" {
+		return nil
+	}
+	for _, entry := range p.proxies {
+		if entry.info.Name == name || entry.info.URL == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (p *ProxyPool) unassign(name string) {
+	for id, assigned := range p.assignments {
+		if assigned == name {
+			delete(p.assignments, id)
+		}
+	}
+}
+
+// load 统计每个代理绑定的会话数量
+func (p *ProxyPool) load() map[string]int {
+	load := map[string]int{}
+	for _, name := range p.assignments {
+		load[name]++
+	}
+	return load
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoEligibleSession 表示没有任何会话满足请求的路由条件
+var ErrNoEligibleSession = errors.New("no eligible session")
+
+// APIKeyPolicy 为单个 API Key 配置的路由策略
+type APIKeyPolicy struct {
+	Key  string   `yaml:"key"`
+	Name string   `yaml:"name"`
+	Tags []string `yaml:"tags"`
+	// ContextStrategy 覆盖全局的 contextStrategy
+	ContextStrategy string `yaml:"contextStrategy"`
+	// PromptFormat 覆盖模型和全局的提示词格式
+	PromptFormat string `yaml:"promptFormat"`
+	// MaxChoices 覆盖全局的 maxChoices
+	MaxChoices int `yaml:"maxChoices"`
+}
+
+// PromptFormat 以 Go text/template 定义的提示词格式，未设置的模板使用 role_prefix 格式的模板
+type PromptFormat struct {
+	// Message 渲染一条消息，数据为 Role、Content、Index 和 Last
+	Message string `yaml:"message"`
+	// Artifacts 启用 promptDisableArtifacts 时加在开头的说明
+	Artifacts string `yaml:"artifacts"`
+	// BigContext 全部消息作为附件发送时的说明，数据为 Files
+	BigContext string `yaml:"bigContext"`
+	// BigContextLatest 较早的消息作为附件、最近的消息保留在提示词中时的说明，数据为 Files
+	BigContextLatest string `yaml:"bigContextLatest"`
+	// Stop 回复中出现时说明模型在替其他角色发言，回复在此截断，见 noRoleLeakGuard
+	Stop []string `yaml:"stop"`
+}
+
+// SessionSelector 描述一次请求对会话池的筛选条件
+type SessionSelector struct {
+	// Tags 会话必须同时拥有的全部标签
+	Tags []string
+	// Model 请求的模型，已检测能力的会话必须支持该模型
+	Model string
+	// Uploads 需要上传的文件数量
+	Uploads int
+	// UploadBytes 需要上传的文件中最大一个的字节数
+	UploadBytes int64
+}
+
+// Empty 判断选择器是否没有任何限制
+func (s SessionSelector) Empty() bool {
+	return len(s.Tags) == 0 && s.Model == "" && s.Uploads == 0 && s.UploadBytes == 0
+}
+
+// Matches 判断会话是否满足选择器的条件
+func (s SessionSelector) Matches(session SessionInfo) bool {
+	return s.Rejection(session) == ""
+}
+
+// Rejection 返回会话不满足选择器的原因，满足时返回空字符串
+func (s SessionSelector) Rejection(session SessionInfo) string {
+	for _, tag := range s.Tags {
+		if !hasTag(session.Tags, tag) {
+			return "missing tag " + tag
+		}
+	}
+	// 被上游拦截的会话在冷却期间不可用
+	if _, blocked := GetEffectiveBlock(session); blocked {
+		return "blocked by upstream"
+	}
+	// 尚未检测能力的会话默认可用，由请求结果决定
+	caps, ok := GetSessionCapabilities(session.ID())
+	if !ok {
+		return ""
+	}
+	if s.Model != "" && !caps.SupportsModel(s.Model) {
+		return "model not supported by plan"
+	}
+	if s.Uploads > caps.MaxUploadFiles {
+		return "too many files for plan"
+	}
+	if s.UploadBytes > caps.MaxUploadBytes {
+		return "file too large for plan"
+	}
+	return ""
+}
+
+// rejectionSummary 汇总各会话被排除的原因，例如 "2 blocked by upstream, 1 missing tag pro"
+func rejectionSummary(reasons []string) string {
+	counts := map[string]int{}
+	var order []string
+	for _, reason := range reasons {
+		if counts[reason] == 0 {
+			order = append(order, reason)
+		}
+		counts[reason]++
+	}
+	parts := make([]string, 0, len(order))
+	for _, reason := range order {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[reason], reason))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WithTags 返回追加了标签（去重）的新选择器
+func (s SessionSelector) WithTags(tags ...string) SessionSelector {
+	merged := append([]string{}, s.Tags...)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || hasTag(merged, tag) {
+			continue
+		}
+		merged = append(merged, tag)
+	}
+	s.Tags = merged
+	return s
+}
+
+func (s SessionSelector) String() string {
+	if s.Empty() {
+		return "any"
+	}
+	var parts []string
+	if len(s.Tags) > 0 {
+		parts = append(parts, fmt.Sprintf("tags=[%s]", strings.Join(s.Tags, ",")))
+	}
+	if s.Model != "" {
+		parts = append(parts, "model="+s.Model)
+	}
+	if s.Uploads > 0 {
+		parts = append(parts, fmt.Sprintf("uploads=%d", s.Uploads))
+	}
+	if s.UploadBytes > 0 {
+		parts = append(parts, fmt.Sprintf("largest_upload=%dB", s.UploadBytes))
+	}
+	return strings.Join(parts, " ")
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTagList 解析逗号分隔的标签列表，例如 "pro,long-context"
+func ParseTagList(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// FindAPIKeyPolicy 根据请求携带的 key 查找对应的策略
+func (c *Config) FindAPIKeyPolicy(key string) (*APIKeyPolicy, bool) {
+	c.RwMutx.RLock()
+	defer c.RwMutx.RUnlock()
+	for i := range c.APIKeys {
+		if c.APIKeys[i].Key != "" && c.APIKeys[i].Key == key {
+			policy := c.APIKeys[i]
+			return &policy, true
+		}
+	}
+	return nil, false
+}
+
+// ModelTagsFor 返回模型映射所要求的会话标签，-think 后缀与基础模型共用配置
+func (c *Config) ModelTagsFor(model string) []string {
+	c.RwMutx.RLock()
+	defer c.RwMutx.RUnlock()
+	if tags, ok := c.ModelTags[model]; ok {
+		return tags
+	}
+	return c.ModelTags[strings.TrimSuffix(model, "-think")]
+}
+
+// ContextStrategyFor 返回请求使用的上下文截断策略，API Key 策略中的设置优先
+func (c *Config) ContextStrategyFor(policy *APIKeyPolicy) string {
+	if policy != nil && policy.ContextStrategy != "" {
+		return policy.ContextStrategy
+	}
+	return c.ContextStrategy
+}
+
+// MaxChoicesFor 返回请求允许的最多选项数量（n），API Key 策略中的设置优先
+func (c *Config) MaxChoicesFor(policy *APIKeyPolicy) int {
+	if policy != nil && policy.MaxChoices > 0 {
+		return policy.MaxChoices
+	}
+	return c.MaxChoices
+}
+
+// PromptFormatFor 返回请求使用的提示词格式名称，依次使用 API Key 策略、模型和全局的设置，
+// 都未设置时返回空字符串
+func (c *Config) PromptFormatFor(policy *APIKeyPolicy, model string) string {
+	c.RwMutx.RLock()
+	defer c.RwMutx.RUnlock()
+	if policy != nil && policy.PromptFormat != "" {
+		return policy.PromptFormat
+	}
+	if format, ok := c.ModelPromptFormats[model]; ok {
+		return format
+	}
+	if format, ok := c.ModelPromptFormats[strings.TrimSuffix(model, "-think")]; ok {
+		return format
+	}
+	return c.PromptFormat
+}
+
+// ContextWindowFor 返回模型的上下文上限（估算的 token 数），-think 后缀与基础模型共用配置
+func (c *Config) ContextWindowFor(model string) int {
+	c.RwMutx.RLock()
+	defer c.RwMutx.RUnlock()
+	if window, ok := c.ModelContextWindows[model]; ok {
+		return window
+	}
+	if window, ok := c.ModelContextWindows[strings.TrimSuffix(model, "-think")]; ok {
+		return window
+	}
+	return c.ModelContextWindow
+}
+
+// CountEligibleSessions 统计满足选择器的会话数量
+func (c *Config) CountEligibleSessions(sel SessionSelector) int {
+	c.RwMutx.RLock()
+	defer c.RwMutx.RUnlock()
+	count := 0
+	for _, session := range c.Sessions {
+		if sel.Matches(session) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionSelectorRejection(t *testing.T) {
+	free := SessionInfo{SessionKey: "free-key", Tags: []string{"pro"}}
+	SetSessionCapabilities(free.ID(), SessionCapabilities{
+		Plan:           PlanFree,
+		Models:         []string{"claude-3-5-haiku-*"},
+		MaxUploadFiles: 2,
+		MaxUploadBytes: 1 << 20,
+	})
+	blocked := SessionInfo{SessionKey: "blocked-key", OrgID: "org"}
+	BlockSession(blocked.ID(), "challenge", time.Minute)
+	unknown := SessionInfo{SessionKey: "unknown-key"}
+
+	tests := []struct {
+		name     string
+		selector SessionSelector
+		session  SessionInfo
+		want     string
+	}{
+		{"eligible", SessionSelector{Model: "claude-3-5-haiku-20241022"}, free, ""},
+		{"missing tag", SessionSelector{Tags: []string{"team"}}, free, "missing tag team"},
+		{"blocked", SessionSelector{}, blocked, "blocked by upstream"},
+		{"model", SessionSelector{Model: "claude-3-opus-20240229"}, free, "model not supported by plan"},
+		{"thinking", SessionSelector{Model: "claude-3-5-haiku-20241022-think"}, free, "model not supported by plan"},
+		{"files", SessionSelector{Uploads: 3}, free, "too many files for plan"},
+		{"file size", SessionSelector{Uploads: 1, UploadBytes: 2 << 20}, free, "file too large for plan"},
+		{"capabilities not detected yet", SessionSelector{Model: "claude-3-opus-20240229", Uploads: 50}, unknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Rejection(tt.session); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectionSummary(t *testing.T) {
+	got := rejectionSummary([]string{"blocked by upstream", "missing tag pro", "blocked by upstream"})
+	if want := "2 blocked by upstream, 1 missing tag pro"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
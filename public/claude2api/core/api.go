@@ -1,420 +1,923 @@
-package core
-
-import (
-	"bufio"
-	"claude2api/logger"
-	"claude2api/model"
-	"encoding/base64"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/imroc/req/v3"
-)
-
-type Client struct {
-	SessionKey   string
-	orgID        string
-	client       *req.Client
-	defaultAttrs map[string]interface{}
-}
-
-type ResponseEvent struct {
-	Type  string `json:"type"`
-	Index int    `json:"index"`
-	Delta struct {
-		Type     string `json:"type"`
-		Text     string `json:"text"`
-		THINKING string `json:"thinking"`
-	} `json:"delta"`
-	Error struct {
-		Message string `json:"message"`
-	} `json:"error"`
-}
-
-func NewClient(sessionKey string, proxy string) *Client {
-	client := req.C().ImpersonateChrome().SetTimeout(time.Minute * 5)
-	client.Transport.SetResponseHeaderTimeout(time.Second * 10)
-	if proxy != "" {
-		client.SetProxyURL(proxy)
-	}
-	// Set common headers
-	headers := map[string]string{
-		"accept":                    "text/event-stream, text/event-stream",
-		"accept-language":           "zh-CN,zh;q=0.9",
-		"anthropic-client-platform": "web_claude_ai",
-		"content-type":              "application/json",
-		"origin":                    "https://claude.ai",
-		"priority":                  "u=1, i",
-	}
-	for key, value := range headers {
-		client.SetCommonHeader(key, value)
-	}
-	// Set cookies
-	client.SetCommonCookies(&http.Cookie{
-		Name:  "sessionKey",
-		Value: sessionKey,
-	})
-	// Create default client with session key
-	c := &Client{
-		SessionKey: sessionKey,
-		client:     client,
-		defaultAttrs: map[string]interface{}{
-			"personalized_styles": []map[string]interface{}{
-				{
-					"type":       "default",
-					"key":        "Default",
-					"name":       "Normal",
-					"nameKey":    "normal_style_name",
-					"prompt":     "Normal",
-					"summary":    "Default responses from Claude",
-					"summaryKey": "normal_style_summary",
-					"isDefault":  true,
-				},
-			},
-			"tools": []map[string]interface{}{
-				{
-					"type": "web_search_v0",
-					"name": "web_search",
-				},
-			},
-			"parent_message_uuid": "00000000-0000-4000-8000-000000000000",
-			"attachments":         []interface{}{},
-			"files":               []interface{}{},
-			"sync_sources":        []interface{}{},
-			"rendering_mode":      "messages",
-			"timezone":            "America/New_York",
-		},
-	}
-	return c
-}
-
-// SetOrgID sets the organization ID for the client
-func (c *Client) SetOrgID(orgID string) {
-	c.orgID = orgID
-}
-func (c *Client) GetOrgID() (string, error) {
-	url := "https://claude.ai/api/organizations"
-	resp, err := c.client.R().
-		SetHeader("referer", "https://claude.ai/new").
-		Get(url)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	type OrgResponse []struct {
-		ID            int    `json:"id"`
-		UUID          string `json:"uuid"`
-		Name          string `json:"name"`
-		RateLimitTier string `json:"rate_limit_tier"`
-	}
-
-	var orgs OrgResponse
-	if err := json.Unmarshal(resp.Bytes(), &orgs); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-	if len(orgs) == 0 {
-		return "", errors.New("no organizations found")
-	}
-	if len(orgs) == 1 {
-		return orgs[0].UUID, nil
-	}
-	for _, org := range orgs {
-		if org.RateLimitTier == "default_claude_ai" {
-			return org.UUID, nil
-		}
-	}
-	return "", errors.New("no default organization found")
-
-}
-
-// CreateConversation creates a new conversation and returns its UUID
-func (c *Client) CreateConversation(model string) (string, error) {
-	if c.orgID == "" {
-		return "", errors.New("organization ID not set")
-	}
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations", c.orgID)
-	// 如果以-think结尾
-	requestBody := map[string]interface{}{
-		"model":                            model,
-		"uuid":                             uuid.New().String(),
-		"name":                             "",
-		"include_conversation_preferences": true,
-	}
-	if len(model) > 6 && model[len(model)-6:] == "-think" {
-		requestBody["paprika_mode"] = "extended"
-		requestBody["model"] = model[:len(model)-6]
-	}
-	resp, err := c.client.R().
-		SetHeader("referer", "https://claude.ai/new").
-		SetBody(requestBody).
-		Post(url)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	var result map[string]interface{}
-	// logger.Info(fmt.Sprintf("create conversation response: %s", resp.String()))
-	if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-	uuid, ok := result["uuid"].(string)
-	if !ok {
-		return "", errors.New("conversation UUID not found in response")
-	}
-	return uuid, nil
-}
-
-// SendMessage sends a message to a conversation and returns the status and response
-func (c *Client) SendMessage(conversationID string, message string, stream bool, gc *gin.Context) (int, error) {
-	if c.orgID == "" {
-		return 500, errors.New("organization ID not set")
-	}
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s/completion",
-		c.orgID, conversationID)
-	// Create request body with default attributes
-	requestBody := c.defaultAttrs
-	requestBody["prompt"] = message
-	// Set up streaming response
-	resp, err := c.client.R().DisableAutoReadResponse().
-		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
-		SetHeader("accept", "text/event-stream, text/event-stream").
-		SetHeader("anthropic-client-platform", "web_claude_ai").
-		SetHeader("cache-control", "no-cache").
-		SetBody(requestBody).
-		Post(url)
-	if err != nil {
-		return 500, fmt.Errorf("request failed: %w", err)
-	}
-	logger.Info(fmt.Sprintf("Claude response status code: %d", resp.StatusCode))
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded")
-	}
-	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	return 200, c.HandleResponse(resp.Body, stream, gc)
-}
-
-// HandleResponse converts Claude's SSE format to OpenAI format and writes to the response writer
-func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context) error {
-	defer body.Close()
-	// Set headers for streaming
-	if stream {
-		gc.Writer.Header().Set("Content-Type", "text/event-stream")
-		gc.Writer.Header().Set("Cache-Control", "no-cache")
-		gc.Writer.Header().Set("Connection", "keep-alive")
-		// 发送200状态码
-		gc.Writer.WriteHeader(http.StatusOK)
-		gc.Writer.Flush()
-	}
-	scanner := bufio.NewScanner(body)
-	clientDone := gc.Request.Context().Done()
-	// Keep track of the full response for the final message
-	thinkingShown := false
-	res_all_text := ""
-	for scanner.Scan() {
-		select {
-		case <-clientDone:
-			// 客户端已断开连接，清理资源并退出
-			logger.Info("Client closed connection")
-			return nil
-		default:
-			// 继续处理响应
-		}
-		line := scanner.Text()
-		// logger.Info(fmt.Sprintf("Claude SSE line: %s", line))
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := line[6:]
-		var event ResponseEvent
-		if err := json.Unmarshal([]byte(data), &event); err == nil {
-			if event.Type == "error" && event.Error.Message != "" {
-				model.ReturnOpenAIResponse(event.Error.Message, stream, gc)
-				return nil
-			}
-			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
-				res_text := event.Delta.Text
-				if thinkingShown {
-					res_text = "</think>\n" + res_text
-					thinkingShown = false
-				}
-				res_all_text += res_text
-				if !stream {
-					continue
-				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
-				continue
-			}
-			if event.Delta.Type == "thinking_delta" {
-				res_text := event.Delta.THINKING
-				if !thinkingShown {
-					res_text = "<think>" + res_text
-					thinkingShown = true
-				}
-				res_all_text += res_text
-				if !stream {
-					continue
-				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
-				continue
-			}
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading response: %w", err)
-	}
-	if !stream {
-		model.ReturnOpenAIResponse(res_all_text, stream, gc)
-	} else {
-		// 发送结束标志
-		gc.Writer.Write([]byte("data: [DONE]\n\n"))
-		gc.Writer.Flush()
-	}
-
-	return nil
-}
-
-// DeleteConversation deletes a conversation by ID
-func (c *Client) DeleteConversation(conversationID string) error {
-	if c.orgID == "" {
-		return errors.New("organization ID not set")
-	}
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s",
-		c.orgID, conversationID)
-	requestBody := map[string]string{
-		"uuid": conversationID,
-	}
-	resp, err := c.client.R().
-		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
-		SetBody(requestBody).
-		Delete(url)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	return nil
-}
-
-// UploadFile uploads files to Claude and adds them to the client's default attributes
-// fileData should be in the format: data:image/jpeg;base64,/9j/4AA...
-func (c *Client) UploadFile(fileData []string) error {
-	if c.orgID == "" {
-		return errors.New("organization ID not set")
-	}
-	if len(fileData) == 0 {
-		return errors.New("empty file data")
-	}
-
-	// Initialize files array in default attributes if it doesn't exist
-	if _, ok := c.defaultAttrs["files"]; !ok {
-		c.defaultAttrs["files"] = []interface{}{}
-	}
-
-	// Process each file
-	for _, fd := range fileData {
-		if fd == "" {
-			continue // Skip empty entries
-		}
-
-		// Parse the base64 data
-		parts := strings.SplitN(fd, ",", 2)
-		if len(parts) != 2 {
-			return errors.New("invalid file data format")
-		}
-
-		// Get the content type from the data URI
-		metaParts := strings.SplitN(parts[0], ":", 2)
-		if len(metaParts) != 2 {
-			return errors.New("invalid content type in file data")
-		}
-
-		metaInfo := strings.SplitN(metaParts[1], ";", 2)
-		if len(metaInfo) != 2 || metaInfo[1] != "base64" {
-			return errors.New("invalid encoding in file data")
-		}
-
-		contentType := metaInfo[0]
-
-		// Decode the base64 data
-		fileBytes, err := base64.StdEncoding.DecodeString(parts[1])
-		if err != nil {
-			return fmt.Errorf("failed to decode base64 data: %w", err)
-		}
-
-		// Determine filename based on content type
-		var filename string
-		switch contentType {
-		case "image/jpeg":
-			filename = "image.jpg"
-		case "image/png":
-			filename = "image.png"
-		case "application/pdf":
-			filename = "document.pdf"
-		default:
-			filename = "file"
-		}
-
-		// Create the upload URL
-		url := fmt.Sprintf("https://claude.ai/api/%s/upload", c.orgID)
-
-		// Create a multipart form request
-		resp, err := c.client.R().
-			SetHeader("referer", "https://claude.ai/new").
-			SetHeader("anthropic-client-platform", "web_claude_ai").
-			SetFileBytes("file", filename, fileBytes).
-			SetContentType("multipart/form-data").
-			Post(url)
-
-		if err != nil {
-			return fmt.Errorf("request failed: %w", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode, resp.String())
-		}
-
-		// Parse the response
-		var result struct {
-			FileUUID string `json:"file_uuid"`
-		}
-
-		if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
-			return fmt.Errorf("failed to parse response: %w", err)
-		}
-
-		if result.FileUUID == "" {
-			return errors.New("file UUID not found in response")
-		}
-
-		// Add file to default attributes
-		c.defaultAttrs["files"] = append(c.defaultAttrs["files"].([]interface{}), result.FileUUID)
-	}
-
-	return nil
-}
-
-func (c *Client) SetBigContext(context string) {
-	c.defaultAttrs["attachments"] = []map[string]interface{}{
-		{
-			"file_name":         "context.txt",
-			"file_type":         "text/plain",
-			"file_size":         len(context),
-			"extracted_content": context,
-		},
-	}
-
-}
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"claude2api/logger"
+	"claude2api/model"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/imroc/req/v3"
+)
+
+// Client is a long-lived claude.ai client for one session. It is safe for
+// concurrent use; per-request state lives in ChatRequest.
+type Client struct {
+	SessionKey   string
+	orgID        string
+	client       *req.Client
+	defaultAttrs map[string]interface{}
+}
+
+// ClientOptions identifies how a Client is built; a pooled client is rebuilt when they change
+type ClientOptions struct {
+	SessionKey string
+	OrgID      string
+	Proxy      string
+	// Browser fingerprint and headers, empty values keep the defaults
+	Impersonate    string // chrome, firefox or safari
+	UserAgent      string
+	AcceptLanguage string
+	Timezone       string
+	Cookies        map[string]string // extra cookies such as cf_clearance
+}
+
+// ChatRequest holds the per-request state of a completion: the prompt,
+// uploaded file UUIDs and text attachments
+type ChatRequest struct {
+	Prompt      string
+	Files       []string
+	Attachments []map[string]interface{}
+	// ParentMessageUUID continues an existing conversation after the given
+	// message; empty starts from the root
+	ParentMessageUUID string
+	// Filter, when set, post-processes the response text sent to the client
+	Filter OutputFilter
+}
+
+// OutputFilter decides how much of the response text reaches the client,
+// e.g. to honor stop sequences and max_tokens. Thinking is not filtered.
+type OutputFilter interface {
+	// Write takes the next text delta and returns the text that can be sent
+	// now; stop reports that the response should end here
+	Write(delta string) (text string, stop bool)
+	// Flush returns the text held back when the response ends normally
+	Flush() string
+	// FinishReason is the OpenAI finish_reason of the response
+	FinishReason() string
+}
+
+// Completion is the outcome of a completion request
+type Completion struct {
+	// MessageUUID is the UUID of the assistant message, empty when claude.ai did not report it
+	MessageUUID string
+	// Text is the full response as returned to the client
+	Text string
+	// Truncated reports that the filter ended the response before claude.ai finished it
+	Truncated bool
+	// FinishReason is the OpenAI finish_reason of the response
+	FinishReason string
+}
+
+type ResponseEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		UUID string `json:"uuid"`
+	} `json:"message"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		THINKING string `json:"thinking"`
+	} `json:"delta"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewClient(sessionKey string, proxy string) *Client {
+	return NewClientWithOptions(ClientOptions{SessionKey: sessionKey, Proxy: proxy})
+}
+
+// NewClientWithOptions creates a client with its own connection pool and cookie jar
+func NewClientWithOptions(opts ClientOptions) *Client {
+	sessionKey := opts.SessionKey
+	client := req.C()
+	switch strings.ToLower(opts.Impersonate) {
+	case "firefox":
+		client.ImpersonateFirefox()
+	case "safari":
+		client.ImpersonateSafari()
+	default:
+		client.ImpersonateChrome()
+	}
+	client.SetTimeout(time.Minute * 5)
+	client.Transport.SetResponseHeaderTimeout(time.Second * 10)
+	if opts.Proxy != "" {
+		client.SetProxyURL(opts.Proxy)
+	}
+	if opts.UserAgent != "" {
+		client.SetUserAgent(opts.UserAgent)
+	}
+	acceptLanguage := opts.AcceptLanguage
+	if acceptLanguage == "" {
+		acceptLanguage = "zh-CN,zh;q=0.9"
+	}
+	timezone := opts.Timezone
+	if timezone == "" {
+		timezone = "America/New_York"
+	}
+	// Set common headers
+	headers := map[string]string{
+		"accept":                    "text/event-stream, text/event-stream",
+		"accept-language":           acceptLanguage,
+		"anthropic-client-platform": "web_claude_ai",
+		"content-type":              "application/json",
+		"origin":                    "https://claude.ai",
+		"priority":                  "u=1, i",
+	}
+	for key, value := range headers {
+		client.SetCommonHeader(key, value)
+	}
+	// Set cookies
+	client.SetCommonCookies(&http.Cookie{
+		Name:  "sessionKey",
+		Value: sessionKey,
+	})
+	for name, value := range opts.Cookies {
+		client.SetCommonCookies(&http.Cookie{
+			Name:  name,
+			Value: value,
+		})
+	}
+	// Create default client with session key
+	c := &Client{
+		SessionKey: sessionKey,
+		orgID:      opts.OrgID,
+		client:     client,
+		defaultAttrs: map[string]interface{}{
+			"personalized_styles": []map[string]interface{}{
+				{
+					"type":       "default",
+					"key":        "Default",
+					"name":       "Normal",
+					"nameKey":    "normal_style_name",
+					"prompt":     "Normal",
+					"summary":    "Default responses from Claude",
+					"summaryKey": "normal_style_summary",
+					"isDefault":  true,
+				},
+			},
+			"tools": []map[string]interface{}{
+				{
+					"type": "web_search_v0",
+					"name": "web_search",
+				},
+			},
+			"parent_message_uuid": "00000000-0000-4000-8000-000000000000",
+			"attachments":         []interface{}{},
+			"files":               []interface{}{},
+			"sync_sources":        []interface{}{},
+			"rendering_mode":      "messages",
+			"timezone":            timezone,
+		},
+	}
+	return c
+}
+
+// SetOrgID sets the organization ID for the client
+func (c *Client) SetOrgID(orgID string) {
+	c.orgID = orgID
+}
+
+// Organization describes an entry returned by /api/organizations
+type Organization struct {
+	ID            int      `json:"id"`
+	UUID          string   `json:"uuid"`
+	Name          string   `json:"name"`
+	RateLimitTier string   `json:"rate_limit_tier"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// GetOrganizations lists all organizations the session key belongs to
+func (c *Client) GetOrganizations() ([]Organization, error) {
+	url := "https://claude.ai/api/organizations"
+	resp, err := c.client.R().
+		SetHeader("referer", "https://claude.ai/new").
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	var orgs []Organization
+	if err := json.Unmarshal(resp.Bytes(), &orgs); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return orgs, nil
+}
+
+// Organization selection policies, see SelectOrganizations
+const (
+	OrgPolicyDefault = "default"
+	OrgPolicyAll     = "all"
+)
+
+// SelectOrganizations picks the organizations to chat with according to policy:
+//
+//	default     the only org, the default_claude_ai org, or else the first chat-capable org
+//	all         every chat-capable org
+//	name:<name> the org with the given name
+//	tier:<tier> the orgs with the given rate_limit_tier
+func SelectOrganizations(orgs []Organization, policy string) ([]Organization, error) {
+	if len(orgs) == 0 {
+		return nil, errors.New("no organizations found")
+	}
+	kind, value, _ := strings.Cut(policy, ":")
+	var selected []Organization
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", OrgPolicyDefault:
+		if len(orgs) == 1 {
+			return orgs, nil
+		}
+		for _, org := range orgs {
+			if org.RateLimitTier == "default_claude_ai" {
+				return []Organization{org}, nil
+			}
+		}
+		for _, org := range orgs {
+			if org.canChat() {
+				return []Organization{org}, nil
+			}
+		}
+		return nil, errors.New("no default organization found")
+	case OrgPolicyAll:
+		for _, org := range orgs {
+			if org.canChat() {
+				selected = append(selected, org)
+			}
+		}
+	case "name":
+		for _, org := range orgs {
+			if strings.EqualFold(org.Name, strings.TrimSpace(value)) {
+				selected = append(selected, org)
+				break
+			}
+		}
+	case "tier":
+		for _, org := range orgs {
+			if org.RateLimitTier == strings.TrimSpace(value) {
+				selected = append(selected, org)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown organization policy: %s", policy)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no organization matches policy %q", policy)
+	}
+	return selected, nil
+}
+
+// canChat reports whether the org can be used for chatting; orgs without
+// reported capabilities are assumed to be usable
+func (o Organization) canChat() bool {
+	if len(o.Capabilities) == 0 {
+		return true
+	}
+	for _, capability := range o.Capabilities {
+		if capability == "chat" {
+			return true
+		}
+	}
+	return false
+}
+
+// ConversationName is the title given to every conversation the proxy creates,
+// so that leftovers can be told apart from the account owner's own chats
+const ConversationName = "claude2api"
+
+// Conversation is a chat conversation as listed by claude.ai
+type Conversation struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsProxyOwned reports whether the conversation was created by the proxy
+func (c Conversation) IsProxyOwned() bool {
+	return c.Name == ConversationName
+}
+
+// ListConversations lists the conversations of the organization, most recent first
+func (c *Client) ListConversations() ([]Conversation, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations", c.orgID)
+	resp, err := c.client.R().
+		SetHeader("referer", "https://claude.ai/recents").
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	var conversations []Conversation
+	if err := json.Unmarshal(resp.Bytes(), &conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return conversations, nil
+}
+
+// GetConversation fetches a conversation with its full message tree, as returned by claude.ai
+func (c *Client) GetConversation(conversationID string) (json.RawMessage, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s",
+		c.orgID, conversationID)
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
+		SetQueryParams(map[string]string{
+			"tree":             "True",
+			"rendering_mode":   "messages",
+			"render_all_tools": "true",
+		}).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	if !json.Valid(resp.Bytes()) {
+		return nil, errors.New("failed to parse response: invalid JSON")
+	}
+	return json.RawMessage(resp.Bytes()), nil
+}
+
+// RenameConversation changes the title of a conversation
+func (c *Client) RenameConversation(conversationID, name string) error {
+	if c.orgID == "" {
+		return errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s",
+		c.orgID, conversationID)
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
+		SetBody(map[string]string{"name": name}).
+		Put(url)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	return checkResponse(resp, http.StatusOK, http.StatusAccepted, http.StatusNoContent)
+}
+
+// CreateConversation creates a new conversation and returns its UUID
+func (c *Client) CreateConversation(model string) (string, error) {
+	if c.orgID == "" {
+		return "", errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations", c.orgID)
+	// 如果以-think结尾
+	requestBody := map[string]interface{}{
+		"model":                            model,
+		"uuid":                             uuid.New().String(),
+		"name":                             ConversationName,
+		"include_conversation_preferences": true,
+	}
+	if len(model) > 6 && model[len(model)-6:] == "-think" {
+		requestBody["paprika_mode"] = "extended"
+		requestBody["model"] = model[:len(model)-6]
+	}
+	resp, err := c.client.R().
+		SetHeader("referer", "https://claude.ai/new").
+		SetBody(requestBody).
+		Post(url)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return "", err
+	}
+	var result map[string]interface{}
+	// logger.Info(fmt.Sprintf("create conversation response: %s", resp.String()))
+	if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	uuid, ok := result["uuid"].(string)
+	if !ok {
+		return "", errors.New("conversation UUID not found in response")
+	}
+	return uuid, nil
+}
+
+// buildCompletionBody merges the request state into a copy of the default attributes
+func (c *Client) buildCompletionBody(chat *ChatRequest) map[string]interface{} {
+	body := make(map[string]interface{}, len(c.defaultAttrs)+1)
+	for key, value := range c.defaultAttrs {
+		body[key] = value
+	}
+	body["prompt"] = chat.Prompt
+	if len(chat.Files) > 0 {
+		body["files"] = chat.Files
+	}
+	if len(chat.Attachments) > 0 {
+		body["attachments"] = chat.Attachments
+	}
+	if chat.ParentMessageUUID != "" {
+		body["parent_message_uuid"] = chat.ParentMessageUUID
+	}
+	return body
+}
+
+// SendMessage sends a message to a conversation, writes the response to the client and returns it
+func (c *Client) SendMessage(conversationID string, chat *ChatRequest, stream bool, gc *gin.Context) (*Completion, error) {
+	// Bound to the downstream request so that a client disconnect aborts the
+	// upstream request as well
+	body, err := c.openCompletion(gc.Request.Context(), conversationID, chat)
+	if err != nil {
+		return nil, err
+	}
+	completion, err := c.HandleResponse(body, stream, gc, chat.Filter)
+	c.stopIfTruncated(conversationID, completion, err)
+	return completion, err
+}
+
+// StreamMessage sends a message to a conversation and passes the response text
+// to emit as it arrives, like HandleResponse but without writing to a client
+func (c *Client) StreamMessage(ctx context.Context, conversationID string, chat *ChatRequest, emit func(text string)) (*Completion, error) {
+	body, err := c.openCompletion(ctx, conversationID, chat)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	completion, err := readResponse(ctx, body, chat.Filter, emit)
+	c.stopIfTruncated(conversationID, completion, err)
+	return completion, err
+}
+
+// stopIfTruncated stops the upstream generation when the filter ended the response early
+func (c *Client) stopIfTruncated(conversationID string, completion *Completion, err error) {
+	if err != nil || !completion.Truncated {
+		return
+	}
+	// The rest of the response is discarded, no need to keep generating it
+	go func() {
+		if err := c.StopResponse(conversationID); err != nil {
+			logger.Error(fmt.Sprintf("Failed to stop response of conversation %s: %v", conversationID, err))
+		}
+	}()
+}
+
+// Complete sends a message to a conversation and returns the response text
+// without writing anything to a client. Thinking is left out of the text.
+func (c *Client) Complete(ctx context.Context, conversationID string, chat *ChatRequest) (*Completion, error) {
+	body, err := c.openCompletion(ctx, conversationID, chat)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var text strings.Builder
+	messageUUID := ""
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event ResponseEvent
+		if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+			continue
+		}
+		switch {
+		case event.Type == "error" && event.Error.Message != "":
+			return nil, fmt.Errorf("completion failed: %s", event.Error.Message)
+		case event.Type == "message_start":
+			messageUUID = event.Message.UUID
+		case event.Delta.Type == "text_delta":
+			text.WriteString(event.Delta.Text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrClientCanceled
+		}
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	return &Completion{MessageUUID: messageUUID, Text: text.String()}, nil
+}
+
+// openCompletion starts a completion request and returns the SSE response body
+func (c *Client) openCompletion(ctx context.Context, conversationID string, chat *ChatRequest) (io.ReadCloser, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s/completion",
+		c.orgID, conversationID)
+	// Create request body with default attributes
+	requestBody := c.buildCompletionBody(chat)
+	resp, err := c.client.R().DisableAutoReadResponse().
+		SetContext(ctx).
+		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
+		SetHeader("accept", "text/event-stream, text/event-stream").
+		SetHeader("anthropic-client-platform", "web_claude_ai").
+		SetHeader("cache-control", "no-cache").
+		SetBody(requestBody).
+		Post(url)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrClientCanceled
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	logger.Info(fmt.Sprintf("Claude response status code: %d", resp.StatusCode))
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// HandleResponse converts Claude's SSE format to OpenAI format and writes to the response writer.
+// The text passes through filter when it is not nil.
+func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context, filter OutputFilter) (*Completion, error) {
+	defer body.Close()
+	if stream {
+		StartStream(gc)
+	}
+	completion, err := readResponse(gc.Request.Context(), body, filter, func(text string) {
+		if stream {
+			model.ReturnOpenAIResponse(text, stream, gc)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !stream {
+		model.ReturnOpenAIFinish(completion.Text, completion.FinishReason, stream, gc)
+	} else {
+		model.ReturnOpenAIFinish("", completion.FinishReason, stream, gc)
+		EndStream(gc)
+	}
+	return completion, nil
+}
+
+// readResponse reads Claude's SSE response and passes every piece of text to
+// emit. Thinking is wrapped in <think> tags, the rest passes through filter
+// when it is not nil.
+func readResponse(ctx context.Context, body io.Reader, filter OutputFilter, emit func(text string)) (*Completion, error) {
+	scanner := bufio.NewScanner(body)
+	clientDone := ctx.Done()
+	// Keep track of the full response for the final message
+	thinkingShown := false
+	res_all_text := ""
+	messageUUID := ""
+	truncated := false
+	for !truncated && scanner.Scan() {
+		select {
+		case <-clientDone:
+			// 客户端已断开连接，清理资源并退出
+			logger.Info("Client closed connection")
+			return nil, ErrClientCanceled
+		default:
+			// 继续处理响应
+		}
+		line := scanner.Text()
+		// logger.Info(fmt.Sprintf("Claude SSE line: %s", line))
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := line[6:]
+		var event ResponseEvent
+		if err := json.Unmarshal([]byte(data), &event); err == nil {
+			if event.Type == "error" && event.Error.Message != "" {
+				emit(event.Error.Message)
+				return &Completion{Text: event.Error.Message, FinishReason: "stop"}, nil
+			}
+			if event.Type == "message_start" {
+				messageUUID = event.Message.UUID
+				continue
+			}
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				res_text := event.Delta.Text
+				if filter != nil {
+					res_text, truncated = filter.Write(res_text)
+				}
+				if thinkingShown {
+					res_text = "</think>\n" + res_text
+					thinkingShown = false
+				}
+				res_all_text += res_text
+				if res_text != "" {
+					emit(res_text)
+				}
+				continue
+			}
+			if event.Delta.Type == "thinking_delta" {
+				res_text := event.Delta.THINKING
+				if !thinkingShown {
+					res_text = "<think>" + res_text
+					thinkingShown = true
+				}
+				res_all_text += res_text
+				emit(res_text)
+				continue
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			logger.Info("Client closed connection")
+			return nil, ErrClientCanceled
+		}
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	finishReason := "stop"
+	if filter != nil {
+		if !truncated {
+			if tail := filter.Flush(); tail != "" {
+				res_all_text += tail
+				emit(tail)
+			}
+		}
+		finishReason = filter.FinishReason()
+	}
+	return &Completion{MessageUUID: messageUUID, Text: res_all_text, Truncated: truncated, FinishReason: finishReason}, nil
+}
+
+// StartStream writes the headers of a streaming response
+func StartStream(gc *gin.Context) {
+	gc.Writer.Header().Set("Content-Type", "text/event-stream")
+	gc.Writer.Header().Set("Cache-Control", "no-cache")
+	gc.Writer.Header().Set("Connection", "keep-alive")
+	// 发送200状态码
+	gc.Writer.WriteHeader(http.StatusOK)
+	gc.Writer.Flush()
+}
+
+// EndStream ends a streaming response
+func EndStream(gc *gin.Context) {
+	// 发送结束标志
+	gc.Writer.Write([]byte("data: [DONE]\n\n"))
+	gc.Writer.Flush()
+}
+
+// WriteResponse writes a complete response to the client in OpenAI format,
+// as a single chunk followed by [DONE] when streaming
+func WriteResponse(text string, stream bool, gc *gin.Context) {
+	if !stream {
+		model.ReturnOpenAIResponse(text, stream, gc)
+		return
+	}
+	StartStream(gc)
+	model.ReturnOpenAIFinish(text, "stop", stream, gc)
+	EndStream(gc)
+}
+
+// StopResponse asks claude.ai to stop generating the current response of a conversation
+func (c *Client) StopResponse(conversationID string) error {
+	if c.orgID == "" {
+		return errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s/stop_response",
+		c.orgID, conversationID)
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
+		Post(url)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	return checkResponse(resp, http.StatusOK, http.StatusNoContent)
+}
+
+// DeleteConversation deletes a conversation by ID
+func (c *Client) DeleteConversation(conversationID string) error {
+	if c.orgID == "" {
+		return errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/chat_conversations/%s",
+		c.orgID, conversationID)
+	requestBody := map[string]string{
+		"uuid": conversationID,
+	}
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("https://claude.ai/chat/%s", conversationID)).
+		SetBody(requestBody).
+		Delete(url)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusOK, http.StatusNoContent); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FileUpload is a file to upload, see UploadFiles
+type FileUpload struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// UploadFile uploads files to Claude and returns their file UUIDs
+// fileData should be in the format: data:image/jpeg;base64,/9j/4AA...
+func (c *Client) UploadFile(fileData []string) ([]string, error) {
+	if len(fileData) == 0 {
+		return nil, errors.New("empty file data")
+	}
+
+	var files []FileUpload
+	for _, fd := range fileData {
+		if fd == "" {
+			continue // Skip empty entries
+		}
+
+		// Parse the base64 data
+		parts := strings.SplitN(fd, ",", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid file data format")
+		}
+
+		// Get the content type from the data URI
+		metaParts := strings.SplitN(parts[0], ":", 2)
+		if len(metaParts) != 2 {
+			return nil, errors.New("invalid content type in file data")
+		}
+
+		metaInfo := strings.SplitN(metaParts[1], ";", 2)
+		if len(metaInfo) != 2 || metaInfo[1] != "base64" {
+			return nil, errors.New("invalid encoding in file data")
+		}
+
+		contentType := metaInfo[0]
+
+		// Decode the base64 data
+		fileBytes, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 data: %w", err)
+		}
+
+		files = append(files, FileUpload{
+			Name:        DefaultFileName(contentType),
+			ContentType: contentType,
+			Data:        fileBytes,
+		})
+	}
+	return c.UploadFiles(files)
+}
+
+// DefaultFileName returns a file name matching the content type, used when the client sent none
+func DefaultFileName(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "image.jpg"
+	case "image/png":
+		return "image.png"
+	case "image/gif":
+		return "image.gif"
+	case "image/webp":
+		return "image.webp"
+	case "application/pdf":
+		return "document.pdf"
+	case "text/plain":
+		return "document.txt"
+	case "text/csv":
+		return "document.csv"
+	case "text/markdown":
+		return "document.md"
+	case "application/json":
+		return "document.json"
+	default:
+		return "file"
+	}
+}
+
+// UploadFiles uploads files to Claude and returns their file UUIDs in the same order
+func (c *Client) UploadFiles(files []FileUpload) ([]string, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	if len(files) == 0 {
+		return nil, errors.New("empty file data")
+	}
+
+	// Reuse files this session already uploaded, and upload the same content only once
+	fileUUIDs := make([]string, len(files))
+	keys := make([]string, len(files))
+	pending := map[string][]int{}
+	var order []string
+	for i, file := range files {
+		keys[i] = uploadKey(c.SessionKey, c.orgID, file)
+		if fileUUID, ok := Uploads.get(keys[i]); ok {
+			fileUUIDs[i] = fileUUID
+			continue
+		}
+		if _, ok := pending[keys[i]]; !ok {
+			order = append(order, keys[i])
+		}
+		pending[keys[i]] = append(pending[keys[i]], i)
+	}
+	if reused := len(files) - len(order); reused > 0 {
+		logger.Info(fmt.Sprintf("Reusing %d previously uploaded files", reused))
+	}
+
+	errs := make([]error, len(order))
+	sem := make(chan struct{}, maxConcurrentUploads)
+	var wg sync.WaitGroup
+	for n, key := range order {
+		wg.Add(1)
+		go func(n int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			indexes := pending[key]
+			file := files[indexes[0]]
+			fileUUID, err := c.uploadFile(file)
+			if err != nil {
+				errs[n] = fmt.Errorf("%s: %w", file.Name, err)
+				return
+			}
+			Uploads.put(key, fileUUID)
+			for _, i := range indexes {
+				fileUUIDs[i] = fileUUID
+			}
+		}(n, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileUUIDs, nil
+}
+
+func (c *Client) uploadFile(file FileUpload) (string, error) {
+	filename := file.Name
+	if filename == "" {
+		filename = DefaultFileName(file.ContentType)
+	}
+
+	// Create the upload URL
+	url := fmt.Sprintf("https://claude.ai/api/%s/upload", c.orgID)
+
+	// Create a multipart form request
+	resp, err := c.client.R().
+		SetHeader("referer", "https://claude.ai/new").
+		SetHeader("anthropic-client-platform", "web_claude_ai").
+		SetFileUpload(req.FileUpload{
+			ParamName:   "file",
+			FileName:    filename,
+			ContentType: file.ContentType,
+			GetFileContent: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(file.Data)), nil
+			},
+			FileSize: int64(len(file.Data)),
+		}).
+		SetContentType("multipart/form-data").
+		Post(url)
+
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", err
+	}
+
+	// Parse the response
+	var result struct {
+		FileUUID string `json:"file_uuid"`
+	}
+
+	if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if result.FileUUID == "" {
+		return "", errors.New("file UUID not found in response")
+	}
+	return result.FileUUID, nil
+}
+
+// AddTextAttachment sends a text file as an attachment with its extracted content
+func (r *ChatRequest) AddTextAttachment(name, contentType, content string) {
+	r.Attachments = append(r.Attachments, map[string]interface{}{
+		"file_name":         name,
+		"file_type":         contentType,
+		"file_size":         len(content),
+		"extracted_content": content,
+	})
+}
+
+// SetBigContext sends part of the context as a text attachment instead of the prompt
+func (r *ChatRequest) SetBigContext(name, context string) {
+	r.AddTextAttachment(name, "text/plain", context)
+}
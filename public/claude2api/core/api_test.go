@@ -0,0 +1,54 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectOrganizations(t *testing.T) {
+	personal := Organization{UUID: "personal", Name: "Personal", RateLimitTier: "default_claude_ai", Capabilities: []string{"chat"}}
+	team := Organization{UUID: "team", Name: "Acme Team", RateLimitTier: "default_raven", Capabilities: []string{"chat", "raven"}}
+	api := Organization{UUID: "api", Name: "API", RateLimitTier: "auto_api_evaluation", Capabilities: []string{"api"}}
+	legacy := Organization{UUID: "legacy", Name: "Legacy", RateLimitTier: "default_raven"}
+
+	tests := []struct {
+		name    string
+		orgs    []Organization
+		policy  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no organizations", policy: "", wantErr: true},
+		{name: "single org is used as is", orgs: []Organization{api}, policy: "", want: []string{"api"}},
+		{name: "default prefers personal org", orgs: []Organization{team, personal}, policy: "default", want: []string{"personal"}},
+		{name: "default falls back to first chat org", orgs: []Organization{api, team}, policy: "", want: []string{"team"}},
+		{name: "default without chat org", orgs: []Organization{api, api}, policy: "", wantErr: true},
+		{name: "all skips orgs without chat", orgs: []Organization{personal, api, team, legacy}, policy: "all", want: []string{"personal", "team", "legacy"}},
+		{name: "policy is case insensitive", orgs: []Organization{personal, team}, policy: "ALL", want: []string{"personal", "team"}},
+		{name: "name matches case insensitively", orgs: []Organization{personal, team}, policy: "name: acme team", want: []string{"team"}},
+		{name: "unknown name", orgs: []Organization{personal, team}, policy: "name:Other", wantErr: true},
+		{name: "tier selects every match", orgs: []Organization{personal, team, legacy}, policy: "tier:default_raven", want: []string{"team", "legacy"}},
+		{name: "unknown policy", orgs: []Organization{personal}, policy: "newest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := SelectOrganizations(tt.orgs, tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", selected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, org := range selected {
+				got = append(got, org.UUID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
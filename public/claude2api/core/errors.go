@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/imroc/req/v3"
+)
+
+// Kinds of upstream failures, see UpstreamError
+const (
+	FailureUnauthorized = "unauthorized" // session key rejected
+	FailureChallenge    = "challenge"    // Cloudflare challenge page
+	FailureBlocked      = "blocked"      // egress blocked, e.g. an HTML 403
+	FailureRateLimited  = "rate_limited" // usage limit reached
+	FailureStatus       = "status"       // any other unexpected status
+)
+
+// ErrClientCanceled is returned when the downstream client went away while
+// the completion was being generated
+var ErrClientCanceled = errors.New("client closed connection")
+
+// Only the beginning of an error body is inspected
+const maxErrorBodySize = 64 << 10
+
+// UpstreamError is returned when claude.ai answers with an unexpected status
+type UpstreamError struct {
+	Kind       string
+	StatusCode int
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	switch e.Kind {
+	case FailureStatus:
+		if e.Message != "" {
+			return fmt.Sprintf("unexpected status code: %d, response: %s", e.StatusCode, e.Message)
+		}
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	case FailureRateLimited:
+		return "rate limit exceeded"
+	default:
+		return fmt.Sprintf("%s (status code %d): %s", e.Kind, e.StatusCode, e.Message)
+	}
+}
+
+// IsEgressBlocked reports whether err means the egress IP was challenged or blocked,
+// as opposed to a problem with the session itself
+func IsEgressBlocked(err error) bool {
+	var upstreamErr *UpstreamError
+	return errors.As(err, &upstreamErr) &&
+		(upstreamErr.Kind == FailureChallenge || upstreamErr.Kind == FailureBlocked)
+}
+
+// FailureKind returns the kind of an upstream failure, or "" for other errors
+func FailureKind(err error) string {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind
+	}
+	return ""
+}
+
+// checkResponse returns nil when the status is one of expected, otherwise an
+// UpstreamError classifying the response
+func checkResponse(resp *req.Response, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	// ToBytes also closes the body of streaming requests
+	body, _ := resp.ToBytes()
+	if len(body) > maxErrorBodySize {
+		body = body[:maxErrorBodySize]
+	}
+	return classifyResponse(resp.StatusCode, resp.Header, string(body))
+}
+
+func classifyResponse(status int, header http.Header, body string) *UpstreamError {
+	isHTML := strings.Contains(header.Get("Content-Type"), "text/html") ||
+		strings.HasPrefix(strings.TrimSpace(strings.ToLower(body)), "<!doctype html") ||
+		strings.HasPrefix(strings.TrimSpace(strings.ToLower(body)), "<html")
+
+	switch {
+	case header.Get("cf-mitigated") == "challenge" ||
+		(isHTML && (strings.Contains(body, "challenge-platform") ||
+			strings.Contains(body, "Just a moment...") ||
+			strings.Contains(body, "cf_chl_opt"))):
+		return &UpstreamError{Kind: FailureChallenge, StatusCode: status, Message: "Cloudflare challenge"}
+	case isHTML && (status == http.StatusForbidden || status == http.StatusServiceUnavailable):
+		return &UpstreamError{Kind: FailureBlocked, StatusCode: status, Message: htmlTitle(body)}
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return &UpstreamError{Kind: FailureUnauthorized, StatusCode: status, Message: truncate(body, 200)}
+	case status == http.StatusTooManyRequests:
+		return &UpstreamError{Kind: FailureRateLimited, StatusCode: status, Message: truncate(body, 200)}
+	default:
+		return &UpstreamError{Kind: FailureStatus, StatusCode: status, Message: truncate(body, 200)}
+	}
+}
+
+// htmlTitle extracts the <title> of an HTML error page, e.g. "Attention Required! | Cloudflare"
+func htmlTitle(body string) string {
+	lower := strings.ToLower(body)
+	start := strings.Index(lower, "<title>")
+	end := strings.Index(lower, "</title>")
+	if start < 0 || end < start {
+		return "blocked by upstream"
+	}
+	return strings.TrimSpace(body[start+len("<title>") : end])
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClassifyResponse(t *testing.T) {
+	htmlHeader := http.Header{"Content-Type": []string{"text/html; charset=UTF-8"}}
+	tests := []struct {
+		name        string
+		status      int
+		header      http.Header
+		body        string
+		wantKind    string
+		wantMessage string
+		wantBlocked bool
+	}{
+		{
+			name:        "challenge header",
+			status:      http.StatusForbidden,
+			header:      http.Header{"Cf-Mitigated": []string{"challenge"}},
+			wantKind:    FailureChallenge,
+			wantMessage: "Cloudflare challenge",
+			wantBlocked: true,
+		},
+		{
+			name:        "challenge page",
+			status:      http.StatusForbidden,
+			header:      htmlHeader,
+			body:        "<html><head><title>Just a moment...</title></head></html>",
+			wantKind:    FailureChallenge,
+			wantMessage: "Cloudflare challenge",
+			wantBlocked: true,
+		},
+		{
+			name:        "html block page without content type",
+			status:      http.StatusForbidden,
+			header:      http.Header{},
+			body:        "<!DOCTYPE html><title>Attention Required! | Cloudflare</title>",
+			wantKind:    FailureBlocked,
+			wantMessage: "Attention Required! | Cloudflare",
+			wantBlocked: true,
+		},
+		{
+			name:        "html 503 without title",
+			status:      http.StatusServiceUnavailable,
+			header:      htmlHeader,
+			body:        "<html><body>error</body></html>",
+			wantKind:    FailureBlocked,
+			wantMessage: "blocked by upstream",
+			wantBlocked: true,
+		},
+		{
+			name:        "json 403 is an unauthorized session",
+			status:      http.StatusForbidden,
+			header:      http.Header{"Content-Type": []string{"application/json"}},
+			body:        ` {"error": "permission denied"} `,
+			wantKind:    FailureUnauthorized,
+			wantMessage: `{"error": "permission denied"}`,
+		},
+		{
+			name:     "401",
+			status:   http.StatusUnauthorized,
+			header:   http.Header{},
+			wantKind: FailureUnauthorized,
+		},
+		{
+			name:        "rate limited",
+			status:      http.StatusTooManyRequests,
+			header:      http.Header{},
+			body:        "limit",
+			wantKind:    FailureRateLimited,
+			wantMessage: "limit",
+		},
+		{
+			name:        "other html status",
+			status:      http.StatusInternalServerError,
+			header:      htmlHeader,
+			body:        "<html>oops</html>",
+			wantKind:    FailureStatus,
+			wantMessage: "<html>oops</html>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyResponse(tt.status, tt.header, tt.body)
+			if err.Kind != tt.wantKind || err.StatusCode != tt.status || err.Message != tt.wantMessage {
+				t.Errorf("got %+v, want kind %q, status %d, message %q", err, tt.wantKind, tt.status, tt.wantMessage)
+			}
+			if IsEgressBlocked(err) != tt.wantBlocked {
+				t.Errorf("IsEgressBlocked = %v, want %v", !tt.wantBlocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("  abcdef  ", 3); got != "abc..." {
+		t.Errorf("truncate = %q, want %q", got, "abc...")
+	}
+	if got := truncate("abc", 3); got != "abc" {
+		t.Errorf("truncate = %q, want %q", got, "abc")
+	}
+	// CJK characters take 3 bytes each and must not be split
+	if got := truncate("请求过多", 7); got != "请求..." {
+		t.Errorf("truncate = %q, want %q", got, "请求...")
+	}
+}
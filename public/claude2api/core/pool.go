@@ -0,0 +1,92 @@
+package core
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Pooled clients unused for this long are dropped and their connections closed
+const clientIdleTimeout = 30 * time.Minute
+
+type pooledClient struct {
+	client   *Client
+	opts     ClientOptions
+	lastUsed time.Time
+}
+
+// ClientPool keeps one long-lived Client per session so that TLS sessions,
+// HTTP/2 connections and cookies are reused across requests
+type ClientPool struct {
+	mu        sync.Mutex
+	clients   map[string]*pooledClient
+	lastSweep time.Time
+}
+
+// Clients is the process wide client pool
+var Clients = NewClientPool()
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{clients: map[string]*pooledClient{}, lastSweep: time.Now()}
+}
+
+// Get returns the pooled client for the session id, creating a new one when
+// none exists or when the options (session key, org, proxy, profile) have changed
+func (p *ClientPool) Get(id string, opts ClientOptions) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.sweep(now)
+
+	if pc, ok := p.clients[id]; ok {
+		if reflect.DeepEqual(pc.opts, opts) {
+			pc.lastUsed = now
+			return pc.client
+		}
+		pc.client.close()
+	}
+	client := NewClientWithOptions(opts)
+	p.clients[id] = &pooledClient{client: client, opts: opts, lastUsed: now}
+	return client
+}
+
+// Invalidate drops the pooled client of a session
+func (p *ClientPool) Invalidate(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc, ok := p.clients[id]; ok {
+		pc.client.close()
+		delete(p.clients, id)
+	}
+}
+
+// RetainSessionKeys drops every pooled client whose session key is not in keys
+func (p *ClientPool) RetainSessionKeys(keys map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pc := range p.clients {
+		if !keys[pc.opts.SessionKey] {
+			pc.client.close()
+			delete(p.clients, id)
+		}
+	}
+}
+
+// sweep drops idle clients, at most once per minute; the caller holds p.mu
+func (p *ClientPool) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < time.Minute {
+		return
+	}
+	p.lastSweep = now
+	for id, pc := range p.clients {
+		if now.Sub(pc.lastUsed) > clientIdleTimeout {
+			pc.client.close()
+			delete(p.clients, id)
+		}
+	}
+}
+
+// close releases the idle connections of the client; in-flight requests are not affected
+func (c *Client) close() {
+	c.client.GetTransport().CloseIdleConnections()
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// CheckProxy verifies that checkURL is reachable through the proxy.
+// Any HTTP response counts as healthy, since claude.ai may answer 403 to bare requests.
+func CheckProxy(proxyURL string, checkURL string, timeout time.Duration) error {
+	client := req.C().ImpersonateChrome().SetTimeout(timeout).SetProxyURL(proxyURL)
+	resp, err := client.R().Head(checkURL)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// IsNetworkError reports whether err was caused by the connection (DNS, dial,
+// proxy handshake or timeout) rather than by an upstream response
+func IsNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Files are uploaded concurrently, at most this many at a time per request
+const maxConcurrentUploads = 4
+
+type cachedUpload struct {
+	fileUUID string
+	expires  time.Time
+}
+
+// UploadCache remembers the file UUIDs of uploaded files by session, org and
+// content, so that files resent in every turn of a chat are uploaded once
+type UploadCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedUpload
+}
+
+// Uploads is the process wide upload cache
+var Uploads = NewUploadCache(time.Hour)
+
+// NewUploadCache creates a cache keeping entries for ttl, a ttl <= 0 disables it
+func NewUploadCache(ttl time.Duration) *UploadCache {
+	return &UploadCache{ttl: ttl, entries: map[string]cachedUpload{}}
+}
+
+// SetTTL changes how long new entries are kept, a ttl <= 0 disables the cache
+func (u *UploadCache) SetTTL(ttl time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.ttl = ttl
+	if ttl <= 0 {
+		u.entries = map[string]cachedUpload{}
+	}
+}
+
+// uploadKey identifies a file uploaded by a session to an organization
+func uploadKey(sessionKey, orgID string, file FileUpload) string {
+	h := sha256.New()
+	h.Write([]byte(sessionKey + "\x00" + orgID + "\x00" + file.Name + "\x00" + file.ContentType + "\x00"))
+	h.Write(file.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (u *UploadCache) get(key string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	entry, ok := u.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(u.entries, key)
+		return "", false
+	}
+	return entry.fileUUID, true
+}
+
+func (u *UploadCache) put(key, fileUUID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	for k, entry := range u.entries {
+		if now.After(entry.expires) {
+			delete(u.entries, k)
+		}
+	}
+	u.entries[key] = cachedUpload{fileUUID: fileUUID, expires: now.Add(u.ttl)}
+}
+
+// Forget drops cached entries of the given file UUIDs, e.g. after claude.ai
+// rejected a completion that referenced them
+func (u *UploadCache) Forget(fileUUIDs []string) {
+	if len(fileUUIDs) == 0 {
+		return
+	}
+	forget := make(map[string]bool, len(fileUUIDs))
+	for _, id := range fileUUIDs {
+		forget[id] = true
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for k, entry := range u.entries {
+		if forget[entry.fileUUID] {
+			delete(u.entries, k)
+		}
+	}
+}
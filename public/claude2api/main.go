@@ -1,19 +1,39 @@
-package main
-
-import (
-	"claude2api/config"
-	"claude2api/router"
-
-	"github.com/gin-gonic/gin"
-)
-
-func main() {
-	r := gin.Default()
-	// Load configuration
-
-	// Setup all routes
-	router.SetupRoutes(r)
-
-	// Run the server on 0.0.0.0:8080
-	r.Run(config.ConfigInstance.Address)
-}
+package main
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/router"
+	"claude2api/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	r := gin.Default()
+	// Load configuration
+
+	// Setup all routes
+	router.SetupRoutes(r)
+
+	// Reuse uploaded files across turns of a chat
+	core.Uploads.SetTTL(time.Duration(config.ConfigInstance.UploadCacheTTL) * time.Minute)
+
+	// Report invalid prompt templates at startup
+	service.CheckPromptFormats()
+
+	// Detect session plans and capabilities in the background
+	service.StartCapabilityRefresher()
+	// Check the health of the egress proxy pool
+	service.StartProxyHealthChecker()
+	// Resume pending conversation deletions
+	service.StartCleanupWorker()
+	// Periodically delete stale conversations left behind by the proxy
+	service.StartConversationSweeper()
+	// Release reused conversations that are no longer continued
+	service.StartConversationReuse()
+
+	// Run the server on 0.0.0.0:8080
+	r.Run(config.ConfigInstance.Address)
+}
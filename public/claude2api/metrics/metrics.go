@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 简单的计数器注册表，以 Prometheus 文本格式输出
+var (
+	mu       sync.Mutex
+	counters = map[string]map[string]float64{} // 指标名 -> 标签 -> 值
+)
+
+// Inc 将计数器加一，labels 为成对的标签名和标签值
+func Inc(name string, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	series, ok := counters[name]
+	if !ok {
+		series = map[string]float64{}
+		counters[name] = series
+	}
+	series[formatLabels(labels)]++
+}
+
+// Gauge 表示输出时临时计算的一个瞬时值
+type Gauge struct {
+	Name   string
+	Labels []string
+	Value  float64
+}
+
+// WritePrometheus 输出所有计数器以及传入的瞬时值
+func WritePrometheus(w io.Writer, gauges ...Gauge) {
+	mu.Lock()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		series := counters[name]
+		labels := make([]string, 0, len(series))
+		for l := range series {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			fmt.Fprintf(w, "%s%s %g\n", name, l, series[l])
+		}
+	}
+	mu.Unlock()
+
+	typed := map[string]bool{}
+	for _, g := range gauges {
+		if !typed[g.Name] {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", g.Name)
+			typed[g.Name] = true
+		}
+		fmt.Fprintf(w, "%s%s %g\n", g.Name, formatLabels(g.Labels), g.Value)
+	}
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"claude2api/config"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware only lets the admin key through; without adminKey configured the global API key is required
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		adminKey := config.ConfigInstance.AdminKey
+		if adminKey == "" {
+			adminKey = config.ConfigInstance.APIKey
+		}
+		if adminKey == "" || key != adminKey {
+			c.JSON(403, gin.H{
+				"error": "Admin access denied",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
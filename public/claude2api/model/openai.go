@@ -1,130 +1,220 @@
-package model
-
-import (
-	"claude2api/logger"
-	"encoding/json"
-	"fmt"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-)
-
-type ChatCompletionRequest struct {
-	Model    string                   `json:"model"`
-	Messages []map[string]interface{} `json:"messages"`
-	Stream   bool                     `json:"stream"`
-	Tools    []map[string]interface{} `json:"tools,omitempty"`
-}
-
-// OpenAISrteamResponse 定义 OpenAI 的流式响应结构
-type OpenAISrteamResponse struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []StreamChoice `json:"choices"`
-}
-
-// Choice 结构表示 OpenAI 返回的单个选项
-type StreamChoice struct {
-	Index        int         `json:"index"`
-	Delta        Delta       `json:"delta"`
-	Logprobs     interface{} `json:"logprobs"`
-	FinishReason interface{} `json:"finish_reason"`
-}
-
-type NoStreamChoice struct {
-	Index        int         `json:"index"`
-	Message      Message     `json:"message"`
-	Logprobs     interface{} `json:"logprobs"`
-	FinishReason string      `json:"finish_reason"`
-}
-
-// Delta 结构用于存储返回的文本内容
-type Delta struct {
-	Content string `json:"content"`
-}
-type Message struct {
-	Role       string        `json:"role"`
-	Content    string        `json:"content"`
-	Refusal    interface{}   `json:"refusal"`
-	Annotation []interface{} `json:"annotation"`
-}
-
-type OpenAIResponse struct {
-	ID      string           `json:"id"`
-	Object  string           `json:"object"`
-	Created int64            `json:"created"`
-	Model   string           `json:"model"`
-	Choices []NoStreamChoice `json:"choices"`
-	Usage   Usage            `json:"usage"`
-}
-type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
-}
-
-func ReturnOpenAIResponse(text string, stream bool, gc *gin.Context) error {
-	if stream {
-		return streamRespose(text, gc)
-	} else {
-		return noStreamResponse(text, gc)
-	}
-}
-
-func streamRespose(text string, gc *gin.Context) error {
-	openAIResp := &OpenAISrteamResponse{
-		ID:      uuid.New().String(),
-		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   "claude-3-7-sonnet-20250219",
-		Choices: []StreamChoice{
-			{
-				Index: 0,
-				Delta: Delta{
-					Content: text,
-				},
-				Logprobs:     nil,
-				FinishReason: nil,
-			},
-		},
-	}
-
-	jsonBytes, err := json.Marshal(openAIResp)
-	jsonBytes = append([]byte("data: "), jsonBytes...)
-	jsonBytes = append(jsonBytes, []byte("\n\n")...)
-	if err != nil {
-		logger.Error(fmt.Sprintf("Error marshalling JSON: %v", err))
-		return err
-	}
-
-	// 发送数据
-	gc.Writer.Write(jsonBytes)
-	gc.Writer.Flush()
-	return nil
-}
-
-func noStreamResponse(text string, gc *gin.Context) error {
-	openAIResp := &OpenAIResponse{
-		ID:      uuid.New().String(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   "claude-3-7-sonnet-20250219",
-		Choices: []NoStreamChoice{
-			{
-				Index: 0,
-				Message: Message{
-					Role:    "assistant",
-					Content: text,
-				},
-				Logprobs:     nil,
-				FinishReason: "stop",
-			},
-		},
-	}
-
-	gc.JSON(200, openAIResp)
-	return nil
-}
+package model
+
+import (
+	"claude2api/logger"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ChatCompletionRequest struct {
+	Model    string                   `json:"model"`
+	Messages []map[string]interface{} `json:"messages"`
+	Stream   bool                     `json:"stream"`
+	Tools    []map[string]interface{} `json:"tools,omitempty"`
+	// ResponseFormat 要求的输出格式：text、json_object 或 json_schema
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Stop 字符串或字符串数组，回复在第一个出现的位置截断
+	Stop                interface{} `json:"stop,omitempty"`
+	MaxTokens           int         `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int         `json:"max_completion_tokens,omitempty"`
+	// N 生成的选项数量，0 与 1 相同
+	N int `json:"n,omitempty"`
+}
+
+// StopSequences 返回请求中的 stop，忽略空字符串
+func (r *ChatCompletionRequest) StopSequences() []string {
+	var stops []string
+	switch v := r.Stop.(type) {
+	case string:
+		if v != "" {
+			stops = append(stops, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				stops = append(stops, s)
+			}
+		}
+	}
+	return stops
+}
+
+// TokenLimit 返回回复的最大 token 数，max_completion_tokens 优先，0 表示不限制
+func (r *ChatCompletionRequest) TokenLimit() int {
+	if r.MaxCompletionTokens > 0 {
+		return r.MaxCompletionTokens
+	}
+	if r.MaxTokens > 0 {
+		return r.MaxTokens
+	}
+	return 0
+}
+
+// ResponseFormat 定义 OpenAI 的 response_format
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema 定义 response_format 中的 json_schema
+type JSONSchema struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+	Strict      bool                   `json:"strict,omitempty"`
+}
+
+// OpenAISrteamResponse 定义 OpenAI 的流式响应结构
+type OpenAISrteamResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+}
+
+// Choice 结构表示 OpenAI 返回的单个选项
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        Delta       `json:"delta"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason interface{} `json:"finish_reason"`
+}
+
+type NoStreamChoice struct {
+	Index        int         `json:"index"`
+	Message      Message     `json:"message"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// Delta 结构用于存储返回的文本内容
+type Delta struct {
+	Content string `json:"content"`
+}
+type Message struct {
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Refusal    interface{}   `json:"refusal"`
+	Annotation []interface{} `json:"annotation"`
+}
+
+type OpenAIResponse struct {
+	ID      string           `json:"id"`
+	Object  string           `json:"object"`
+	Created int64            `json:"created"`
+	Model   string           `json:"model"`
+	Choices []NoStreamChoice `json:"choices"`
+	Usage   Usage            `json:"usage"`
+}
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func ReturnOpenAIResponse(text string, stream bool, gc *gin.Context) error {
+	if stream {
+		return streamRespose(0, text, nil, gc)
+	} else {
+		return noStreamResponse(text, "stop", gc)
+	}
+}
+
+// ReturnOpenAIFinish 结束回复：流式时发送带 finish_reason 的最后一块，非流式时返回完整回复
+func ReturnOpenAIFinish(text string, finishReason string, stream bool, gc *gin.Context) error {
+	if stream {
+		return streamRespose(0, text, finishReason, gc)
+	}
+	return noStreamResponse(text, finishReason, gc)
+}
+
+// ReturnOpenAIChunk 发送流式响应中第 index 个选项的一块，finishReason 为 nil 表示该选项尚未结束
+func ReturnOpenAIChunk(index int, text string, finishReason interface{}, gc *gin.Context) error {
+	return streamRespose(index, text, finishReason, gc)
+}
+
+// ReturnOpenAIChoices 返回包含多个选项的非流式响应，texts 和 finishReasons 按选项顺序
+func ReturnOpenAIChoices(texts []string, finishReasons []string, gc *gin.Context) error {
+	choices := make([]NoStreamChoice, len(texts))
+	for i, text := range texts {
+		choices[i] = NoStreamChoice{
+			Index: i,
+			Message: Message{
+				Role:    "assistant",
+				Content: text,
+			},
+			Logprobs:     nil,
+			FinishReason: finishReasons[i],
+		}
+	}
+	gc.JSON(200, newOpenAIResponse(choices))
+	return nil
+}
+
+// ReturnOpenAIStreamError 在流式响应中发送错误事件，格式与 OpenAI 流中的错误相同
+func ReturnOpenAIStreamError(message string, gc *gin.Context) error {
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "upstream_error",
+		},
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshalling JSON: %v", err))
+		return err
+	}
+	gc.Writer.Write([]byte("data: " + string(jsonBytes) + "\n\n"))
+	gc.Writer.Flush()
+	return nil
+}
+
+func streamRespose(index int, text string, finishReason interface{}, gc *gin.Context) error {
+	openAIResp := &OpenAISrteamResponse{
+		ID:      uuid.New().String(),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   "claude-3-7-sonnet-20250219",
+		Choices: []StreamChoice{
+			{
+				Index: index,
+				Delta: Delta{
+					Content: text,
+				},
+				Logprobs:     nil,
+				FinishReason: finishReason,
+			},
+		},
+	}
+
+	jsonBytes, err := json.Marshal(openAIResp)
+	jsonBytes = append([]byte("data: "), jsonBytes...)
+	jsonBytes = append(jsonBytes, []byte("\n\n")...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshalling JSON: %v", err))
+		return err
+	}
+
+	// 发送数据
+	gc.Writer.Write(jsonBytes)
+	gc.Writer.Flush()
+	return nil
+}
+
+func noStreamResponse(text string, finishReason string, gc *gin.Context) error {
+	return ReturnOpenAIChoices([]string{text}, []string{finishReason}, gc)
+}
+
+func newOpenAIResponse(choices []NoStreamChoice) *OpenAIResponse {
+	return &OpenAIResponse{
+		ID:      uuid.New().String(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   "claude-3-7-sonnet-20250219",
+		Choices: choices,
+	}
+}
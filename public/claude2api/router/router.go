@@ -1,37 +1,73 @@
-package router
-
-import (
-	"claude2api/config"
-	"claude2api/middleware"
-	"claude2api/service"
-
-	"github.com/gin-gonic/gin"
-)
-
-func SetupRoutes(r *gin.Engine) {
-	// Apply middleware
-	r.Use(middleware.CORSMiddleware())
-	r.Use(middleware.AuthMiddleware())
-
-	// Health check endpoint
-	r.GET("/health", service.HealthCheckHandler)
-
-	// Chat completions endpoint (OpenAI-compatible)
-	r.POST("/v1/chat/completions", service.ChatCompletionsHandler)
-	r.GET("/v1/models", service.MoudlesHandler)
-
-	if config.ConfigInstance.EnableMirrorApi {
-		r.POST(config.ConfigInstance.MirrorApiPrefix+"/v1/chat/completions", service.MirrorChatHandler)
-		r.GET(config.ConfigInstance.MirrorApiPrefix+"/v1/models", service.MoudlesHandler)
-	}
-
-	// HuggingFace compatible routes
-	hfRouter := r.Group("/hf")
-	{
-		v1Router := hfRouter.Group("/v1")
-		{
-			v1Router.POST("/chat/completions", service.ChatCompletionsHandler)
-			v1Router.GET("/models", service.MoudlesHandler)
-		}
-	}
-}
+package router
+
+import (
+	"claude2api/config"
+	"claude2api/middleware"
+	"claude2api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupRoutes(r *gin.Engine) {
+	// Apply middleware
+	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.AuthMiddleware())
+
+	// Health check endpoint
+	r.GET("/health", service.HealthCheckHandler)
+
+	// Chat completions endpoint (OpenAI-compatible)
+	r.POST("/v1/chat/completions", service.ChatCompletionsHandler)
+	r.GET("/v1/models", service.MoudlesHandler)
+
+	// Files API, files are stored locally and referenced by file_id in chat messages
+	r.POST("/v1/files", service.UploadFileHandler)
+	r.GET("/v1/files", service.ListFilesHandler)
+	r.GET("/v1/files/:file_id", service.GetFileHandler)
+	r.GET("/v1/files/:file_id/content", service.GetFileContentHandler)
+	r.DELETE("/v1/files/:file_id", service.DeleteFileHandler)
+
+	// Threads API, each thread is a claude.ai conversation on a pinned session
+	r.POST("/v1/threads", service.CreateThreadHandler)
+	r.GET("/v1/threads/:thread_id", service.GetThreadHandler)
+	r.DELETE("/v1/threads/:thread_id", service.DeleteThreadHandler)
+	r.POST("/v1/threads/:thread_id/messages", service.CreateThreadMessageHandler)
+	r.GET("/v1/threads/:thread_id/messages", service.ListThreadMessagesHandler)
+	r.POST("/v1/threads/:thread_id/runs", service.CreateThreadRunHandler)
+
+	if config.ConfigInstance.EnableMirrorApi {
+		r.POST(config.ConfigInstance.MirrorApiPrefix+"/v1/chat/completions", service.MirrorChatHandler)
+		r.GET(config.ConfigInstance.MirrorApiPrefix+"/v1/models", service.MoudlesHandler)
+	}
+
+	// Admin routes
+	adminRouter := r.Group("/admin", middleware.AdminMiddleware())
+	{
+		adminRouter.GET("/status", service.AdminStatusHandler)
+		adminRouter.GET("/metrics", service.AdminMetricsHandler)
+		adminRouter.GET("/proxies", service.AdminListProxiesHandler)
+		adminRouter.POST("/proxies", service.AdminAddProxyHandler)
+		adminRouter.DELETE("/proxies/:name", service.AdminDeleteProxyHandler)
+		adminRouter.POST("/proxies/check", service.AdminCheckProxiesHandler)
+		adminRouter.PUT("/sessions/proxy", service.AdminAssignProxyHandler)
+		adminRouter.GET("/cleanup", service.AdminListCleanupHandler)
+		adminRouter.POST("/cleanup/retry", service.AdminRetryCleanupHandler)
+		adminRouter.DELETE("/cleanup/:id", service.AdminDeleteCleanupHandler)
+		adminRouter.GET("/sweep", service.AdminLastSweepHandler)
+		adminRouter.POST("/sweep", service.AdminSweepHandler)
+		adminRouter.GET("/sessions/:session/conversations", service.AdminListConversationsHandler)
+		adminRouter.GET("/sessions/:session/conversations/:id", service.AdminGetConversationHandler)
+		adminRouter.PUT("/sessions/:session/conversations/:id", service.AdminRenameConversationHandler)
+		adminRouter.DELETE("/sessions/:session/conversations/:id", service.AdminDeleteConversationHandler)
+	}
+
+	// HuggingFace compatible routes
+	hfRouter := r.Group("/hf")
+	{
+		v1Router := hfRouter.Group("/v1")
+		{
+			v1Router.POST("/chat/completions", service.ChatCompletionsHandler)
+			v1Router.GET("/models", service.MoudlesHandler)
+		}
+	}
+}
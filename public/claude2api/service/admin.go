@@ -0,0 +1,200 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/metrics"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maskSessionID 隐藏池条目 ID 中的会话密钥
+func maskSessionID(session config.SessionInfo) string {
+	if session.OrgID == "" {
+		return config.MaskSessionKey(session.SessionKey)
+	}
+	return config.MaskSessionKey(session.SessionKey) + ":" + session.OrgID
+}
+
+// findPoolSession 根据会话密钥和组织 ID 在会话池中查找条目，orgID 为空时匹配该密钥的第一个条目
+func findPoolSession(sessionKey, orgID string) (config.SessionInfo, bool) {
+	config.ConfigInstance.RwMutx.RLock()
+	defer config.ConfigInstance.RwMutx.RUnlock()
+	for _, session := range config.ConfigInstance.Sessions {
+		if session.SessionKey == sessionKey && (orgID == "" || session.OrgID == orgID) {
+			return session, true
+		}
+	}
+	return config.SessionInfo{}, false
+}
+
+// AdminListProxiesHandler 返回代理池状态以及会话与代理的绑定关系
+func AdminListProxiesHandler(c *gin.Context) {
+	assignments := config.Proxies.Assignments()
+	sessions := map[string]string{}
+	config.ConfigInstance.RwMutx.RLock()
+	for _, session := range config.ConfigInstance.Sessions {
+		if name, ok := assignments[session.ID()]; ok {
+			sessions[maskSessionID(session)] = name
+		}
+	}
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"proxies":     config.Proxies.Status(),
+		"assignments": sessions,
+	})
+}
+
+// AdminAddProxyHandler 向代理池添加代理
+func AdminAddProxyHandler(c *gin.Context) {
+	var proxy config.ProxyInfo
+	if err := c.ShouldBindJSON(&proxy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	if err := config.Proxies.Add(proxy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"proxies": config.Proxies.Status(),
+	})
+}
+
+// AdminDeleteProxyHandler 从代理池删除代理，使用它的会话会被重新分配
+func AdminDeleteProxyHandler(c *gin.Context) {
+	if !config.Proxies.Remove(c.Param("name")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Proxy not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"proxies": config.Proxies.Status(),
+	})
+}
+
+// AdminCheckProxiesHandler 立即检查所有代理
+func AdminCheckProxiesHandler(c *gin.Context) {
+	checkAllProxies()
+	c.JSON(http.StatusOK, gin.H{
+		"proxies": config.Proxies.Status(),
+	})
+}
+
+// AdminAssignProxyHandler 将会话固定到指定代理
+func AdminAssignProxyHandler(c *gin.Context) {
+	var body struct {
+		SessionKey string `json:"sessionKey" binding:"required"`
+		OrgID      string `json:"orgID"`
+		Proxy      string `json:"proxy" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	session, ok := findPoolSession(body.SessionKey, body.OrgID)
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Session not found",
+		})
+		return
+	}
+	if err := config.Proxies.Assign(session.ID(), body.Proxy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"session": maskSessionID(session),
+		"proxy":   body.Proxy,
+	})
+}
+
+// sessionStatus 管理接口中展示的池条目状态
+type sessionStatus struct {
+	Index        int                         `json:"index"`
+	ID           string                      `json:"id"`
+	Tags         []string                    `json:"tags,omitempty"`
+	Profile      string                      `json:"profile,omitempty"`
+	Proxy        string                      `json:"proxy,omitempty"`
+	Capabilities *config.SessionCapabilities `json:"capabilities,omitempty"`
+	BlockedUntil *time.Time                  `json:"blockedUntil,omitempty"`
+	BlockReason  string                      `json:"blockReason,omitempty"`
+}
+
+func collectSessionStatus() []sessionStatus {
+	assignments := config.Proxies.Assignments()
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := append([]config.SessionInfo{}, config.ConfigInstance.Sessions...)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	status := make([]sessionStatus, 0, len(sessions))
+	for i, session := range sessions {
+		st := sessionStatus{
+			Index:   i,
+			ID:      maskSessionID(session),
+			Tags:    session.Tags,
+			Profile: session.Profile,
+			Proxy:   assignments[session.ID()],
+		}
+		if caps, ok := config.GetSessionCapabilities(session.ID()); ok {
+			st.Capabilities = &caps
+		}
+		if block, ok := config.GetEffectiveBlock(session); ok {
+			st.BlockedUntil = &block.Until
+			st.BlockReason = block.Reason
+		}
+		status = append(status, st)
+	}
+	return status
+}
+
+// AdminStatusHandler 返回会话池和代理池的状态，包括被拦截的原因和冷却时间
+func AdminStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": collectSessionStatus(),
+		"proxies":  config.Proxies.Status(),
+	})
+}
+
+// AdminMetricsHandler 以 Prometheus 文本格式输出指标
+func AdminMetricsHandler(c *gin.Context) {
+	sessions := collectSessionStatus()
+	blockedSessions := 0
+	for _, st := range sessions {
+		if st.BlockedUntil != nil {
+			blockedSessions++
+		}
+	}
+	proxies := config.Proxies.Status()
+	unhealthyProxies, blockedProxies := 0, 0
+	for _, p := range proxies {
+		if !p.Healthy {
+			unhealthyProxies++
+		}
+		if p.BlockReason != "" {
+			blockedProxies++
+		}
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	metrics.WritePrometheus(c.Writer,
+		metrics.Gauge{Name: "claude2api_sessions", Value: float64(len(sessions))},
+		metrics.Gauge{Name: "claude2api_sessions_blocked", Value: float64(blockedSessions)},
+		metrics.Gauge{Name: "claude2api_proxies", Value: float64(len(proxies))},
+		metrics.Gauge{Name: "claude2api_proxies_unhealthy", Value: float64(unhealthyProxies)},
+		metrics.Gauge{Name: "claude2api_proxies_blocked", Value: float64(blockedProxies)},
+	)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"fmt"
+	"time"
+)
+
+// StartCapabilityRefresher 启动后台协程，定期检测每个会话的组织、套餐和可用能力
+func StartCapabilityRefresher() {
+	config.OnSessionsReloaded = onSessionsReloaded
+	interval := config.ConfigInstance.CapabilityRefresh
+	if interval <= 0 {
+		logger.Info("Session capability refresh is disabled")
+		return
+	}
+	go func() {
+		for {
+			refreshAllCapabilities()
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+// onSessionsReloaded 丢弃已移除会话的客户端，并重新检测组织和能力
+func onSessionsReloaded() {
+	config.ConfigInstance.RwMutx.RLock()
+	keys := make(map[string]bool, len(config.ConfigInstance.Sessions))
+	for _, session := range config.ConfigInstance.Sessions {
+		keys[session.SessionKey] = true
+	}
+	config.ConfigInstance.RwMutx.RUnlock()
+	core.Clients.RetainSessionKeys(keys)
+	refreshAllCapabilities()
+}
+
+func refreshAllCapabilities() {
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := append([]config.SessionInfo{}, config.ConfigInstance.Sessions...)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	for _, session := range sessions {
+		proxy := config.Proxies.ProxyFor(session)
+		client := sessionClient(session, proxy)
+		_, err := resolveSessionOrgs(client, session)
+		reportUpstreamResult(session, proxy, err)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to detect capabilities for session %s: %v", config.MaskSessionKey(session.SessionKey), err))
+		}
+	}
+}
+
+// sessionClient 返回会话在客户端池中的长连接客户端，使用会话的出口代理和客户端配置
+func sessionClient(session config.SessionInfo, proxy string) *core.Client {
+	profile := config.ConfigInstance.ProfileFor(session)
+	return core.Clients.Get(session.ID(), core.ClientOptions{
+		SessionKey:     session.SessionKey,
+		OrgID:          session.OrgID,
+		Proxy:          proxy,
+		Impersonate:    profile.Impersonate,
+		UserAgent:      profile.UserAgent,
+		AcceptLanguage: profile.AcceptLanguage,
+		Timezone:       profile.Timezone,
+		Cookies:        profile.Cookies,
+	})
+}
+
+// resolveSessionOrgs 按组织选择策略确定会话使用的组织并记录每个组织的能力。
+// 尚未确定组织的会话会被展开为每个组织一个池条目，返回的第一个组织用于当前请求
+func resolveSessionOrgs(client *core.Client, session config.SessionInfo) ([]core.Organization, error) {
+	orgs, err := client.GetOrganizations()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []core.Organization
+	if session.OrgID != "" {
+		for _, org := range orgs {
+			if org.UUID == session.OrgID {
+				selected = append(selected, org)
+				break
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("organization %s not found for session", session.OrgID)
+		}
+	} else {
+		selected, err = core.SelectOrganizations(orgs, config.ConfigInstance.OrgPolicyFor(session))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	orgIDs := make([]string, 0, len(selected))
+	for _, org := range selected {
+		entry := session
+		entry.OrgID = org.UUID
+		caps := config.ConfigInstance.DetectCapabilities(org.UUID, org.RateLimitTier, org.Capabilities)
+		config.SetSessionCapabilities(entry.ID(), caps)
+		logger.Info(fmt.Sprintf("Session %s org %s (%s): plan %s, tier %s, thinking %t, models %v",
+			config.MaskSessionKey(session.SessionKey), org.UUID, org.Name, caps.Plan, caps.Tier, caps.Thinking, caps.Models))
+		orgIDs = append(orgIDs, org.UUID)
+	}
+	if session.OrgID == "" {
+		config.ConfigInstance.ExpandSessionOrgs(session.SessionKey, orgIDs)
+	}
+	return selected, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// choiceCount 返回请求的选项数量（n），超出 API Key 或全局的 maxChoices 时写入错误响应并返回 false
+func choiceCount(c *gin.Context, req *model.ChatCompletionRequest) (int, bool) {
+	n := req.N
+	if n == 0 {
+		n = 1
+	}
+	limit := config.ConfigInstance.MaxChoicesFor(apiKeyPolicy(c))
+	if n < 1 || n > limit {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("n must be between 1 and %d", limit),
+		})
+		return 0, false
+	}
+	return n, true
+}
+
+// handleChoices 同时发送 n 次请求，每个选项使用单独的对话，按 index 返回全部选项。
+// nextSession 返回每次尝试使用的会话，每个选项最多尝试 attempts 次。
+// 流式响应中出错的选项以一个错误事件结束；非流式时任一选项失败则整个请求失败
+func handleChoices(c *gin.Context, n int, modelName string, processor *utils.ChatRequestProcessor, stream bool, attempts int, nextSession func() (config.SessionInfo, error)) {
+	ctx := c.Request.Context()
+	logger.Info(fmt.Sprintf("Sending %d choices for model %s", n, modelName))
+
+	var mu sync.Mutex
+	if stream {
+		core.StartStream(c)
+	}
+	texts := make([]string, n)
+	reasons := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			emit := func(text string) {
+				if !stream {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				model.ReturnOpenAIChunk(index, text, nil, c)
+			}
+			completion, err := runChoice(ctx, index, modelName, processor, attempts, nextSession, emit)
+			if err != nil {
+				errs[index] = err
+			} else {
+				texts[index] = completion.Text
+				reasons[index] = completion.FinishReason
+			}
+			if !stream || ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				logger.Error(fmt.Sprintf("Choice %d failed: %v", index, err))
+				model.ReturnOpenAIStreamError(fmt.Sprintf("choice %d failed: %v", index, err), c)
+				return
+			}
+			model.ReturnOpenAIChunk(index, "", reasons[index], c)
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	if stream {
+		core.EndStream(c)
+		return
+	}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		logger.Error(fmt.Sprintf("Choice %d failed: %v", i, err))
+		var outputErr *invalidOutputError
+		switch {
+		case errors.As(err, &outputErr):
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+				Error: err.Error(),
+			})
+		case errors.Is(err, config.ErrNoEligibleSession):
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error: err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to process request after multiple attempts",
+			})
+		}
+		return
+	}
+	model.ReturnOpenAIChoices(texts, reasons, c)
+}
+
+// runChoice 生成一个选项，失败且尚未输出内容时换用下一个会话重试
+func runChoice(ctx context.Context, index int, model string, processor *utils.ChatRequestProcessor, attempts int, nextSession func() (config.SessionInfo, error), emit func(string)) (*core.Completion, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		session, err := nextSession()
+		if err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		logger.Info(fmt.Sprintf("Using session for choice %d of model %s: %s (Attempt %d/%d)",
+			index, model, config.MaskSessionKey(session.SessionKey), attempt, attempts))
+		emitted := false
+		completion, err := sendChoice(ctx, session, model, processor.Clone(), func(text string) {
+			emitted = true
+			emit(text)
+		})
+		if err == nil {
+			return completion, nil
+		}
+		lastErr = err
+		// 已输出的内容无法撤回；客户端断开或 JSON 输出不符合要求时重试也没有意义
+		var outputErr *invalidOutputError
+		if emitted || ctx.Err() != nil || errors.As(err, &outputErr) {
+			break
+		}
+		logger.Info(fmt.Sprintf("Session %s failed for choice %d: %v", config.MaskSessionKey(session.SessionKey), index, err))
+	}
+	return nil, lastErr
+}
+
+// sendChoice 使用指定会话在新对话中生成一个选项，回复文本交给 emit，完成后按 chatDelete 删除对话
+func sendChoice(ctx context.Context, session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, emit func(string)) (*core.Completion, error) {
+	session, proxy, claudeClient, err := prepareSession(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get org ID: %w", err)
+	}
+	if caps, ok := config.GetSessionCapabilities(session.ID()); ok && !caps.SupportsModel(model) {
+		return nil, fmt.Errorf("session plan %s does not support model %s", caps.Plan, model)
+	}
+	chat, err := buildChatRequest(claudeClient, session, proxy, processor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	conversationID, err := claudeClient.CreateConversation(model)
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	var completion *core.Completion
+	if processor.Output != nil {
+		completion, err = completeStructured(ctx, claudeClient, conversationID, chat, processor.Output)
+		if err == nil {
+			emit(completion.Text)
+		}
+	} else {
+		completion, err = claudeClient.StreamMessage(ctx, conversationID, chat, emit)
+	}
+
+	finishConversation(claudeClient, session, proxy, conversationID, chat, err, false)
+	return completion, err
+}
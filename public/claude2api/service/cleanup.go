@@ -0,0 +1,243 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cleanupQueueFile    = "cleanupQueue.json"
+	cleanupPollInterval = 5 * time.Second
+	cleanupBaseBackoff  = 5 * time.Second
+	cleanupMaxBackoff   = 30 * time.Minute
+)
+
+// CleanupTask 待删除的上游对话
+type CleanupTask struct {
+	SessionKey     string    `json:"sessionKey"`
+	OrgID          string    `json:"orgID"`
+	ConversationID string    `json:"conversationID"`
+	Attempts       int       `json:"attempts"`
+	NextAttempt    time.Time `json:"nextAttempt"`
+	LastError      string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// CleanupQueue 持久化的对话删除队列，进程重启后继续处理
+type CleanupQueue struct {
+	mu    sync.Mutex
+	tasks []*CleanupTask
+}
+
+// Cleanup 全局对话删除队列
+var Cleanup = &CleanupQueue{}
+
+// StartCleanupWorker 从数据目录恢复删除队列并启动后台处理协程
+func StartCleanupWorker() {
+	Cleanup.mu.Lock()
+	if err := config.LoadDataFile(cleanupQueueFile, &Cleanup.tasks); err != nil {
+		logger.Error(fmt.Sprintf("Failed to load cleanup queue: %v", err))
+	}
+	pending := len(Cleanup.tasks)
+	Cleanup.mu.Unlock()
+	if pending > 0 {
+		logger.Info(fmt.Sprintf("Restored %d pending conversation deletions", pending))
+	}
+
+	go func() {
+		for {
+			Cleanup.processDue()
+			time.Sleep(cleanupPollInterval)
+		}
+	}()
+}
+
+// Enqueue 将对话加入删除队列，已在队列中的对话不会重复加入
+func (q *CleanupQueue) Enqueue(session config.SessionInfo, conversationID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, task := range q.tasks {
+		if task.ConversationID == conversationID {
+			return
+		}
+	}
+	now := time.Now()
+	q.tasks = append(q.tasks, &CleanupTask{
+		SessionKey:     session.SessionKey,
+		OrgID:          session.OrgID,
+		ConversationID: conversationID,
+		NextAttempt:    now,
+		CreatedAt:      now,
+	})
+	q.save()
+}
+
+// Tasks 返回队列快照
+func (q *CleanupQueue) Tasks() []CleanupTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks := make([]CleanupTask, 0, len(q.tasks))
+	for _, task := range q.tasks {
+		tasks = append(tasks, *task)
+	}
+	return tasks
+}
+
+// Remove 从队列中移除对话，返回是否存在
+func (q *CleanupQueue) Remove(conversationID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, task := range q.tasks {
+		if task.ConversationID == conversationID {
+			q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+			q.save()
+			return true
+		}
+	}
+	return false
+}
+
+// RetryNow 让所有任务立即重试
+func (q *CleanupQueue) RetryNow() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, task := range q.tasks {
+		task.NextAttempt = now
+	}
+	q.save()
+}
+
+// processDue 处理所有到期的任务，失败的任务按指数退避重新安排
+func (q *CleanupQueue) processDue() {
+	q.mu.Lock()
+	now := time.Now()
+	var due []CleanupTask
+	for _, task := range q.tasks {
+		if !task.NextAttempt.After(now) {
+			due = append(due, *task)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, task := range due {
+		err := deleteConversation(task)
+		q.finish(task.ConversationID, err)
+	}
+}
+
+func (q *CleanupQueue) finish(conversationID string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, task := range q.tasks {
+		if task.ConversationID != conversationID {
+			continue
+		}
+		if err == nil {
+			logger.Info(fmt.Sprintf("Successfully deleted conversation: %s", conversationID))
+			q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+			q.save()
+			return
+		}
+		task.Attempts++
+		task.LastError = err.Error()
+		if task.Attempts >= config.ConfigInstance.CleanupMaxAttempts {
+			logger.Error(fmt.Sprintf("Cleanup %s conversation %s failed after %d attempts, giving up: %v",
+				config.MaskSessionKey(task.SessionKey), conversationID, task.Attempts, err))
+			q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+			q.save()
+			return
+		}
+		backoff := cleanupBaseBackoff << (task.Attempts - 1)
+		if backoff <= 0 || backoff > cleanupMaxBackoff {
+			backoff = cleanupMaxBackoff
+		}
+		task.NextAttempt = time.Now().Add(backoff)
+		logger.Error(fmt.Sprintf("Failed to delete conversation %s (attempt %d), retrying in %s: %v", conversationID, task.Attempts, backoff, err))
+		q.save()
+		return
+	}
+}
+
+// save 持久化队列，调用方需持有 q.mu
+func (q *CleanupQueue) save() {
+	if err := config.SaveDataFile(cleanupQueueFile, q.tasks); err != nil {
+		logger.Error(fmt.Sprintf("Failed to save cleanup queue: %v", err))
+	}
+}
+
+// deleteConversation 使用会话当前的代理和客户端配置删除对话
+func deleteConversation(task CleanupTask) error {
+	session, ok := findPoolSession(task.SessionKey, task.OrgID)
+	if !ok {
+		session = config.SessionInfo{SessionKey: task.SessionKey, OrgID: task.OrgID}
+	}
+	session.OrgID = task.OrgID
+	proxy := config.Proxies.ProxyFor(session)
+	err := sessionClient(session, proxy).DeleteConversation(task.ConversationID)
+	reportUpstreamResult(session, proxy, err)
+	// 对话已不存在视为删除成功
+	var upstreamErr *core.UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// cleanupTaskView 管理接口中展示的删除任务，隐藏会话密钥
+type cleanupTaskView struct {
+	Session        string    `json:"session"`
+	ConversationID string    `json:"conversationID"`
+	Attempts       int       `json:"attempts"`
+	NextAttempt    time.Time `json:"nextAttempt"`
+	LastError      string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// AdminListCleanupHandler 返回待删除的对话
+func AdminListCleanupHandler(c *gin.Context) {
+	tasks := Cleanup.Tasks()
+	views := make([]cleanupTaskView, 0, len(tasks))
+	for _, task := range tasks {
+		views = append(views, cleanupTaskView{
+			Session:        maskSessionID(config.SessionInfo{SessionKey: task.SessionKey, OrgID: task.OrgID}),
+			ConversationID: task.ConversationID,
+			Attempts:       task.Attempts,
+			NextAttempt:    task.NextAttempt,
+			LastError:      task.LastError,
+			CreatedAt:      task.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": views,
+	})
+}
+
+// AdminRetryCleanupHandler 让所有待删除的对话立即重试
+func AdminRetryCleanupHandler(c *gin.Context) {
+	Cleanup.RetryNow()
+	c.JSON(http.StatusOK, gin.H{
+		"pending": len(Cleanup.Tasks()),
+	})
+}
+
+// AdminDeleteCleanupHandler 放弃删除某个对话
+func AdminDeleteCleanupHandler(c *gin.Context) {
+	if !Cleanup.Remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Cleanup task not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"pending": len(Cleanup.Tasks()),
+	})
+}
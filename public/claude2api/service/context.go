@@ -0,0 +1,103 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const summarizePrompt = "The attached files contain the earlier part of a conversation. Summarize it concisely for the assistant who will continue it: keep facts, decisions, open questions, names, numbers and code that later messages may refer to. Reply with the summary only."
+
+// applyContextStrategy 请求超出模型的上下文上限时按 API Key 或全局配置的策略截断消息，
+// 仍然超出时写入错误响应并返回 false
+func applyContextStrategy(c *gin.Context, processor *utils.ChatRequestProcessor, model string) bool {
+	strategy := config.ConfigInstance.ContextStrategyFor(apiKeyPolicy(c))
+
+	// 镜像接口使用调用方自己的会话，不把内容发送到池中的会话
+	var summarize utils.Summarizer
+	if !c.GetBool("UseMirrorApi") {
+		selector := buildSessionSelector(c, model)
+		summarize = func(history string) (string, error) {
+			return summarizeHistory(c.Request.Context(), selector, model, history)
+		}
+	}
+	if err := processor.ApplyContextStrategy(strategy, model, summarize); err != nil {
+		logger.Error(fmt.Sprintf("Failed to apply context strategy: %v", err))
+	}
+
+	if err := processor.CheckContextWindow(model); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Context too long: %v", err),
+		})
+		return false
+	}
+	return true
+}
+
+// summarizeHistory 在池中会话的一个单独对话里总结较早的消息，完成后删除该对话
+func summarizeHistory(ctx context.Context, selector config.SessionSelector, model, history string) (string, error) {
+	session, err := config.Sr.NextSession(selector)
+	if err != nil {
+		return "", fmt.Errorf("no session available: %w", err)
+	}
+	session, proxy, claudeClient, err := prepareSession(session)
+	if err != nil {
+		return "", err
+	}
+	conversationID, err := claudeClient.CreateConversation(model)
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		return "", err
+	}
+	if config.ConfigInstance.ChatDelete {
+		defer Cleanup.Enqueue(session, conversationID)
+	}
+
+	chat := &core.ChatRequest{Prompt: summarizePrompt}
+	for _, file := range utils.SplitContextFile("history.txt", history, config.ConfigInstance.ContextFileTokens) {
+		chat.SetBigContext(file.Name, file.Content)
+	}
+	completion, err := claudeClient.Complete(ctx, conversationID, chat)
+	if !errors.Is(err, core.ErrClientCanceled) {
+		reportUpstreamResult(session, proxy, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	summary := strings.TrimSpace(utils.NormalizeReply(completion.Text))
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+	logger.Info(fmt.Sprintf("Summarized %d tokens of history into %d tokens", utils.EstimateTokens(history), utils.EstimateTokens(summary)))
+	return summary, nil
+}
+
+// CheckPromptFormats 启动时编译配置中用到的提示词格式，格式不存在或模板有误时记录日志
+func CheckPromptFormats() {
+	names := map[string]bool{config.ConfigInstance.PromptFormat: true}
+	for name := range config.ConfigInstance.PromptFormats {
+		names[name] = true
+	}
+	for _, name := range config.ConfigInstance.ModelPromptFormats {
+		names[name] = true
+	}
+	for _, policy := range config.ConfigInstance.APIKeys {
+		names[policy.PromptFormat] = true
+	}
+	for name := range names {
+		if name == "" {
+			continue
+		}
+		if _, err := utils.LoadPromptFormat(name); err != nil {
+			logger.Error(fmt.Sprintf("Prompt format %s will fall back to the default: %v", name, err))
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationView 管理接口中展示的对话
+type conversationView struct {
+	core.Conversation
+	// ProxyOwned 对话是否由代理创建
+	ProxyOwned bool `json:"proxy_owned"`
+	// Thread 对话是否属于某个线程
+	Thread bool `json:"thread"`
+}
+
+// adminSessionClient 根据路径中的会话 ID（/admin/status 中的 id，即脱敏的密钥加组织 ID）返回会话的客户端，失败时写入错误响应
+func adminSessionClient(c *gin.Context) (config.SessionInfo, string, *core.Client, bool) {
+	session, found := poolSessionByID(c.Param("session"))
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Session not found",
+		})
+		return session, "", nil, false
+	}
+
+	session, proxy, client, err := prepareSession(session)
+	if err != nil {
+		adminUpstreamError(c, session, err)
+		return session, proxy, nil, false
+	}
+	return session, proxy, client, true
+}
+
+// poolSessionByID 根据 maskSessionID 生成的 ID 查找池条目，池中条目增减不会改变其他条目的 ID。
+// 多个密钥脱敏后相同时无法区分，视为未找到
+func poolSessionByID(id string) (config.SessionInfo, bool) {
+	masked, orgID, _ := strings.Cut(id, ":")
+	config.ConfigInstance.RwMutx.RLock()
+	sessionKey := ""
+	for _, session := range config.ConfigInstance.Sessions {
+		if config.MaskSessionKey(session.SessionKey) != masked || session.OrgID != orgID {
+			continue
+		}
+		if sessionKey != "" && sessionKey != session.SessionKey {
+			config.ConfigInstance.RwMutx.RUnlock()
+			return config.SessionInfo{}, false
+		}
+		sessionKey = session.SessionKey
+	}
+	config.ConfigInstance.RwMutx.RUnlock()
+	if sessionKey == "" {
+		return config.SessionInfo{}, false
+	}
+	return findPoolSession(sessionKey, orgID)
+}
+
+func adminUpstreamError(c *gin.Context, session config.SessionInfo, err error) {
+	logger.Error(fmt.Sprintf("Admin request for session %s failed: %v", maskSessionID(session), err))
+	c.JSON(http.StatusBadGateway, ErrorResponse{
+		Error: fmt.Sprintf("Upstream request failed: %v", err),
+	})
+}
+
+// AdminListConversationsHandler 列出会话在 claude.ai 上的对话
+func AdminListConversationsHandler(c *gin.Context) {
+	session, proxy, client, ok := adminSessionClient(c)
+	if !ok {
+		return
+	}
+	conversations, err := client.ListConversations()
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		adminUpstreamError(c, session, err)
+		return
+	}
+	views := make([]conversationView, 0, len(conversations))
+	for _, conversation := range conversations {
+		views = append(views, conversationView{
+			Conversation: conversation,
+			ProxyOwned:   conversation.IsProxyOwned(),
+			Thread:       Threads.OwnsConversation(conversation.UUID),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"session":       maskSessionID(session),
+		"conversations": views,
+	})
+}
+
+// AdminGetConversationHandler 返回对话及其完整的消息树
+func AdminGetConversationHandler(c *gin.Context) {
+	session, proxy, client, ok := adminSessionClient(c)
+	if !ok {
+		return
+	}
+	conversation, err := client.GetConversation(c.Param("id"))
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		adminUpstreamError(c, session, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", conversation)
+}
+
+// AdminRenameConversationHandler 修改对话标题
+func AdminRenameConversationHandler(c *gin.Context) {
+	var body struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	session, proxy, client, ok := adminSessionClient(c)
+	if !ok {
+		return
+	}
+	err := client.RenameConversation(c.Param("id"), body.Name)
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		adminUpstreamError(c, session, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"uuid": c.Param("id"),
+		"name": body.Name,
+	})
+}
+
+// AdminDeleteConversationHandler 立即删除对话
+func AdminDeleteConversationHandler(c *gin.Context) {
+	session, proxy, client, ok := adminSessionClient(c)
+	if !ok {
+		return
+	}
+	conversationID := c.Param("id")
+	// 线程和对话复用还会继续使用这些对话，删除后它们的下一次请求会在上游失败
+	if Threads.OwnsConversation(conversationID) || reusableConversations.holds(conversationID) {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: "Conversation is in use by a thread or held for reuse",
+		})
+		return
+	}
+	err := client.DeleteConversation(conversationID)
+	reportUpstreamResult(session, proxy, err)
+	if err != nil {
+		adminUpstreamError(c, session, err)
+		return
+	}
+	// 已删除的对话不再需要排队删除
+	Cleanup.Remove(conversationID)
+	logger.Info(fmt.Sprintf("Deleted conversation %s of session %s via admin API", conversationID, maskSessionID(session)))
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":    conversationID,
+		"deleted": true,
+	})
+}
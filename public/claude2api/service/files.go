@@ -0,0 +1,166 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileObject 文件的 OpenAI 风格表示
+type fileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+func newFileObject(file utils.StoredFile) fileObject {
+	return fileObject{
+		ID:        file.ID,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt.Unix(),
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+	}
+}
+
+func fileNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: "File not found",
+	})
+}
+
+// UploadFileHandler 接收 multipart 上传的文件（file 和 purpose 字段）并保存到本地，
+// 之后可在聊天消息中通过 file_id 引用
+func UploadFileHandler(c *gin.Context) {
+	maxBytes := int64(config.ConfigInstance.FilesMaxMB) << 20
+	// 为 multipart 的其他字段留出余量
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error: fmt.Sprintf("File is larger than %d MB", config.ConfigInstance.FilesMaxMB),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	if header.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error: fmt.Sprintf("File is larger than %d MB", config.ConfigInstance.FilesMaxMB),
+		})
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		purpose = "user_data"
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return
+	}
+
+	filename := filepath.Base(header.Filename)
+	contentType, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byName := mime.TypeByExtension(filepath.Ext(filename)); byName != "" {
+			contentType, _, _ = mime.ParseMediaType(byName)
+		}
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	file, err := utils.Files.Create(keyOwner(c), filename, contentType, purpose, data)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to store file %s: %v", filename, err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to store file",
+		})
+		return
+	}
+	logger.Info(fmt.Sprintf("Stored file %s (%s, %d bytes)", file.ID, file.Filename, file.Bytes))
+	c.JSON(http.StatusOK, newFileObject(file))
+}
+
+// ListFilesHandler 列出当前 API Key 上传的文件，?purpose= 按用途过滤
+func ListFilesHandler(c *gin.Context) {
+	files := utils.Files.List(keyOwner(c), c.Query("purpose"))
+	data := make([]fileObject, 0, len(files))
+	for _, file := range files {
+		data = append(data, newFileObject(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// GetFileHandler 返回文件信息
+func GetFileHandler(c *gin.Context) {
+	file, ok := utils.Files.Get(c.Param("file_id"), keyOwner(c))
+	if !ok {
+		fileNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, newFileObject(file))
+}
+
+// GetFileContentHandler 返回文件内容
+func GetFileContentHandler(c *gin.Context) {
+	file, data, err := utils.Files.Open(c.Param("file_id"), keyOwner(c))
+	if errors.Is(err, utils.ErrFileNotFound) {
+		fileNotFound(c)
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to read file %s: %v", c.Param("file_id"), err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to read file",
+		})
+		return
+	}
+	c.Data(http.StatusOK, file.ContentType, data)
+}
+
+// DeleteFileHandler 删除文件
+func DeleteFileHandler(c *gin.Context) {
+	fileID := c.Param("file_id")
+	if !utils.Files.Delete(fileID, keyOwner(c)) {
+		fileNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      fileID,
+		"object":  "file",
+		"deleted": true,
+	})
+}
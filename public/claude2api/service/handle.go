@@ -1,257 +1,535 @@
-package service
-
-import (
-	"claude2api/config"
-	"claude2api/core"
-	"claude2api/logger"
-	"claude2api/model"
-	"claude2api/utils"
-	"fmt"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/gin-gonic/gin"
-)
-
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
-// HealthCheckHandler handles the health check endpoint
-func HealthCheckHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
-	})
-}
-
-// ChatCompletionsHandler handles the chat completions endpoint
-func ChatCompletionsHandler(c *gin.Context) {
-	useMirror, exist := c.Get("UseMirrorApi")
-	if exist && useMirror.(bool) {
-		MirrorChatHandler(c)
-		return
-	}
-
-	// Parse and validate request
-	req, err := parseAndValidateRequest(c)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("Invalid request: %v", err),
-		})
-		return
-	}
-
-	// Process messages into prompt and extract images
-	processor := utils.NewChatRequestProcessor()
-	processor.ProcessMessages(req.Messages)
-
-	// Get model or use default
-	model := getModelOrDefault(req.Model)
-	
-	// 重置重试计数器，准备开始新的请求
-	config.Sr.ResetRetryCount()
-	
-	// 轮询尝试所有会话，直到成功或达到最大重试次数
-	for {
-		// 获取下一个会话，带重试计数
-		session, err := config.Sr.GetNextSessionWithRetry()
-		if err != nil {
-			// 如果所有重试都失败，返回错误
-			logger.Error(fmt.Sprintf("Failed to get session after maximum retries: %v", err))
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error: "Failed to process request after multiple attempts",
-			})
-			return
-		}
-
-		// 记录当前使用的会话信息
-		logger.Info(fmt.Sprintf("Using session for model %s: %s (Attempt %d/%d)", 
-			model, 
-			session.SessionKey,
-			config.Sr.RetryCount,
-			config.ConfigInstance.RetryCount))
-
-		// 如果不是第一次尝试，重置提示内容
-		if config.Sr.RetryCount > 1 {
-			processor.Prompt.Reset()
-			processor.Prompt.WriteString(processor.RootPrompt.String())
-		}
-
-		// 处理请求
-		if handleChatRequest(c, session, model, processor, req.Stream) {
-			// 成功处理，重置重试计数器
-			config.Sr.ResetRetryCount()
-			return
-		}
-
-		// 如果请求失败，记录日志并继续尝试下一个会话
-		logger.Info(fmt.Sprintf("Session %s failed, trying next session", session.SessionKey))
-	}
-}
-
-func MoudlesHandler(c *gin.Context) {
-	models := []map[string]interface{}{
-		{"id": "claude-3-7-sonnet-20250219"},
-		{"id": "claude-3-7-sonnet-20250219-think"},
-	}
-	c.JSON(http.StatusOK, gin.H{
-		"data": models,
-	})
-}
-
-func MirrorChatHandler(c *gin.Context) {
-	if !config.ConfigInstance.EnableMirrorApi {
-		c.JSON(http.StatusForbidden, ErrorResponse{
-			Error: "Mirror API is not enabled",
-		})
-		return
-	}
-
-	// Parse and validate request
-	req, err := parseAndValidateRequest(c)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("Invalid request: %v", err),
-		})
-		return
-	}
-
-	// Process messages into prompt and extract images
-	processor := utils.NewChatRequestProcessor()
-	processor.ProcessMessages(req.Messages)
-
-	// Get model or use default
-	model := getModelOrDefault(req.Model)
-
-	// Extract session info from auth header
-	session, err := extractSessionFromAuthHeader(c)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("Invalid authorization: %v", err),
-		})
-		return
-	}
-
-	// Process the request with the provided session
-	if !handleChatRequest(c, session, model, processor, req.Stream) {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: "Failed to process request",
-		})
-		return
-	}
-}
-
-// Helper functions
-
-func parseAndValidateRequest(c *gin.Context) (*model.ChatCompletionRequest, error) {
-	var req model.ChatCompletionRequest
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("Invalid request: %v", err),
-		})
-		return nil, err
-	}
-
-	if len(req.Messages) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: "No messages provided",
-		})
-		return nil, fmt.Errorf("no messages provided")
-	}
-
-	return &req, nil
-}
-
-func getModelOrDefault(model string) string {
-	if model == "" {
-		return "claude-3-7-sonnet-20250219"
-	}
-	return model
-}
-
-func extractSessionFromAuthHeader(c *gin.Context) (config.SessionInfo, error) {
-	authInfo := c.Request.Header.Get("Authorization")
-	authInfo = strings.TrimPrefix(authInfo, "Bearer ")
-
-	if authInfo == "" {
-		return config.SessionInfo{SessionKey: "", OrgID: ""}, fmt.Errorf("missing authorization header")
-	}
-
-	if strings.Contains(authInfo, ":") {
-		parts := strings.Split(authInfo, ":")
-		return config.SessionInfo{SessionKey: parts[0], OrgID: parts[1]}, nil
-	}
-
-	return config.SessionInfo{SessionKey: authInfo, OrgID: ""}, nil
-}
-
-func handleChatRequest(c *gin.Context, session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, stream bool) bool {
-	// Initialize the Claude client
-	claudeClient := core.NewClient(session.SessionKey, config.ConfigInstance.Proxy)
-
-	// Get org ID if not already set
-	if session.OrgID == "" {
-		orgId, err := claudeClient.GetOrgID()
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get org ID: %v", err))
-			return false
-		}
-		session.OrgID = orgId
-		config.ConfigInstance.SetSessionOrgID(session.SessionKey, session.OrgID)
-	}
-
-	claudeClient.SetOrgID(session.OrgID)
-
-	// Upload images if any
-	if len(processor.ImgDataList) > 0 {
-		err := claudeClient.UploadFile(processor.ImgDataList)
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to upload file: %v", err))
-			return false
-		}
-	}
-
-	// Handle large context if needed
-	if processor.Prompt.Len() > config.ConfigInstance.MaxChatHistoryLength {
-		claudeClient.SetBigContext(processor.Prompt.String())
-		processor.ResetForBigContext()
-		logger.Info(fmt.Sprintf("Prompt length exceeds max limit (%d), using file context", config.ConfigInstance.MaxChatHistoryLength))
-	}
-
-	// Create conversation
-	conversationID, err := claudeClient.CreateConversation(model)
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to create conversation: %v", err))
-		return false
-	}
-
-	// Send message
-	if _, err := claudeClient.SendMessage(conversationID, processor.Prompt.String(), stream, c); err != nil {
-		logger.Error(fmt.Sprintf("Failed to send message: %v", err))
-		go cleanupConversation(claudeClient, conversationID, 3)
-		return false
-	}
-
-	// Clean up conversation if enabled
-	if config.ConfigInstance.ChatDelete {
-		go cleanupConversation(claudeClient, conversationID, 3)
-	}
-
-	return true
-}
-
-func cleanupConversation(client *core.Client, conversationID string, retry int) {
-	for i := 0; i < retry; i++ {
-		if err := client.DeleteConversation(conversationID); err != nil {
-			logger.Error(fmt.Sprintf("Failed to delete conversation: %v", err))
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		logger.Info(fmt.Sprintf("Successfully deleted conversation: %s", conversationID))
-		return // 成功后直接返回，不执行后面的错误日志
-	}
-	// 只有当所有重试都失败后，才会执行到这里
-	logger.Error(fmt.Sprintf("Cleanup %s conversation %s failed after %d retries", client.SessionKey, conversationID, retry))
-}
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// HealthCheckHandler handles the health check endpoint
+func HealthCheckHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// ChatCompletionsHandler handles the chat completions endpoint
+func ChatCompletionsHandler(c *gin.Context) {
+	useMirror, exist := c.Get("UseMirrorApi")
+	if exist && useMirror.(bool) {
+		MirrorChatHandler(c)
+		return
+	}
+
+	// Parse and validate request
+	req, err := parseAndValidateRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	n, ok := choiceCount(c, req)
+	if !ok {
+		return
+	}
+
+	// Download and preprocess images once, so that every session uploads the same bytes
+	if err := utils.PrepareImages(c.Request.Context(), req.Messages); err != nil {
+		logger.Error(fmt.Sprintf("Failed to prepare images: %v", err))
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid image: %v", err),
+		})
+		return
+	}
+
+	// Get model or use default
+	model := getModelOrDefault(req.Model)
+
+	// Process messages into prompt and extract images
+	processor := utils.NewChatRequestProcessor()
+	processor.FileOwner = keyOwner(c)
+	processor.Format = promptFormatFor(c, model)
+	processor.ProcessMessages(req.Messages)
+	if err := processor.DocumentError(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return
+	}
+	output, err := utils.ParseResponseFormat(req.ResponseFormat)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid response_format: %v", err),
+		})
+		return
+	}
+	processor.SetOutput(output)
+	processor.SetOutputLimits(req.StopSequences(), req.TokenLimit())
+	if !applyContextStrategy(c, processor, model) {
+		return
+	}
+
+	// 根据请求头、API Key 策略、模型映射和账号能力确定可用的会话范围
+	selector := buildSessionSelector(c, model)
+	selector.Uploads = processor.UploadCount()
+	selector.UploadBytes = processor.LargestUpload()
+
+	// 消息列表延续了之前的对话时，先尝试在原对话中只发送新的用户消息。
+	// JSON 输出可能经过多轮纠正，预填的回复与上游对话中的内容不一致，多个选项各自使用新对话，都不复用对话
+	var reuseKey string
+	var previous *reusedConversation
+	if output == nil && processor.Prefill == "" && n == 1 {
+		reuseKey, previous = reusableConversations.match(reuseScope(keyOwner(c), processor.Format), req.Messages, model)
+	}
+	if previous != nil {
+		if continueConversation(c, previous, reuseKey, req, model, selector) {
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		logger.Info(fmt.Sprintf("Failed to continue conversation %s, resending full history", previous.ConversationID))
+	}
+	var reuse *reuseTarget
+	if reuseKey != "" {
+		reuse = &reuseTarget{Key: reuseKey}
+	}
+
+	// 多个选项同时在轮询到的不同会话中生成
+	if n > 1 {
+		handleChoices(c, n, model, processor, req.Stream, max(config.ConfigInstance.RetryCount, 1), func() (config.SessionInfo, error) {
+			return config.Sr.NextSession(selector)
+		})
+		return
+	}
+
+	// 重置重试计数器，准备开始新的请求
+	config.Sr.ResetRetryCount()
+
+	// 轮询尝试所有会话，直到成功或达到最大重试次数
+	for {
+		// 获取下一个会话，带重试计数
+		session, err := config.Sr.GetNextSessionWithRetry(selector)
+		if errors.Is(err, config.ErrNoEligibleSession) {
+			logger.Error(fmt.Sprintf("No session available for model %s: %v", model, err))
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error: fmt.Sprintf("No session available: %v", err),
+			})
+			return
+		}
+		if err != nil {
+			// 如果所有重试都失败，返回错误
+			logger.Error(fmt.Sprintf("Failed to get session after maximum retries: %v", err))
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to process request after multiple attempts",
+			})
+			return
+		}
+
+		// 记录当前使用的会话信息
+		logger.Info(fmt.Sprintf("Using session for model %s: %s (Attempt %d/%d)",
+			model,
+			session.SessionKey,
+			config.Sr.RetryCount,
+			config.ConfigInstance.RetryCount))
+
+		// 如果不是第一次尝试，重置提示内容
+		if config.Sr.RetryCount > 1 {
+			processor.Prompt.Reset()
+			processor.Prompt.WriteString(processor.RootPrompt.String())
+		}
+
+		// 处理请求
+		if handleChatRequest(c, session, model, processor, req.Stream, reuse) {
+			// 成功处理，重置重试计数器
+			config.Sr.ResetRetryCount()
+			return
+		}
+
+		// 客户端已断开时不再尝试其他会话
+		if c.Request.Context().Err() != nil {
+			logger.Info("Client closed connection, giving up retries")
+			return
+		}
+
+		// 如果请求失败，记录日志并继续尝试下一个会话
+		logger.Info(fmt.Sprintf("Session %s failed, trying next session", session.SessionKey))
+	}
+}
+
+func MoudlesHandler(c *gin.Context) {
+	models := []map[string]interface{}{
+		{"id": "claude-3-7-sonnet-20250219"},
+		{"id": "claude-3-7-sonnet-20250219-think"},
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": models,
+	})
+}
+
+func MirrorChatHandler(c *gin.Context) {
+	if !config.ConfigInstance.EnableMirrorApi {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Mirror API is not enabled",
+		})
+		return
+	}
+
+	// Parse and validate request
+	req, err := parseAndValidateRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	n, ok := choiceCount(c, req)
+	if !ok {
+		return
+	}
+
+	// Download and preprocess images once, so that every session uploads the same bytes
+	if err := utils.PrepareImages(c.Request.Context(), req.Messages); err != nil {
+		logger.Error(fmt.Sprintf("Failed to prepare images: %v", err))
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid image: %v", err),
+		})
+		return
+	}
+
+	// Get model or use default
+	model := getModelOrDefault(req.Model)
+
+	// Process messages into prompt and extract images
+	processor := utils.NewChatRequestProcessor()
+	processor.FileOwner = keyOwner(c)
+	processor.Format = promptFormatFor(c, model)
+	processor.ProcessMessages(req.Messages)
+	if err := processor.DocumentError(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return
+	}
+	output, err := utils.ParseResponseFormat(req.ResponseFormat)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid response_format: %v", err),
+		})
+		return
+	}
+	processor.SetOutput(output)
+	processor.SetOutputLimits(req.StopSequences(), req.TokenLimit())
+	if !applyContextStrategy(c, processor, model) {
+		return
+	}
+
+	// Extract session info from auth header
+	session, err := extractSessionFromAuthHeader(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid authorization: %v", err),
+		})
+		return
+	}
+
+	// 多个选项在调用方的会话中各使用一个对话
+	if n > 1 {
+		handleChoices(c, n, model, processor, req.Stream, 1, func() (config.SessionInfo, error) {
+			return session, nil
+		})
+		return
+	}
+
+	// Process the request with the provided session
+	if !handleChatRequest(c, session, model, processor, req.Stream, nil) {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to process request",
+		})
+		return
+	}
+}
+
+// Helper functions
+
+func parseAndValidateRequest(c *gin.Context) (*model.ChatCompletionRequest, error) {
+	var req model.ChatCompletionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return nil, err
+	}
+
+	if len(req.Messages) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "No messages provided",
+		})
+		return nil, fmt.Errorf("no messages provided")
+	}
+
+	return &req, nil
+}
+
+// buildSessionSelector 合并 X-Session-Tags 请求头、API Key 策略和模型映射中的标签
+func buildSessionSelector(c *gin.Context, model string) config.SessionSelector {
+	selector := config.SessionSelector{Model: model}
+	if policy := apiKeyPolicy(c); policy != nil {
+		selector = selector.WithTags(policy.Tags...)
+	}
+	selector = selector.WithTags(config.ConfigInstance.ModelTagsFor(model)...)
+	selector = selector.WithTags(config.ParseTagList(c.GetHeader("X-Session-Tags"))...)
+	return selector
+}
+
+// apiKeyPolicy 返回请求使用的 API Key 策略，主 APIKEY 和镜像接口返回 nil
+func apiKeyPolicy(c *gin.Context) *config.APIKeyPolicy {
+	if value, exists := c.Get("APIKeyPolicy"); exists {
+		if policy, ok := value.(*config.APIKeyPolicy); ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// promptFormatFor 返回 API Key 策略、模型或全局配置的提示词格式，未配置或格式无效时返回 nil（使用默认格式）
+func promptFormatFor(c *gin.Context, model string) *utils.PromptFormat {
+	name := config.ConfigInstance.PromptFormatFor(apiKeyPolicy(c), model)
+	if name == "" {
+		return nil
+	}
+	format, err := utils.LoadPromptFormat(name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load prompt format: %v", err))
+		return nil
+	}
+	return format
+}
+
+func getModelOrDefault(model string) string {
+	if model == "" {
+		return "claude-3-7-sonnet-20250219"
+	}
+	return model
+}
+
+func extractSessionFromAuthHeader(c *gin.Context) (config.SessionInfo, error) {
+	authInfo := c.Request.Header.Get("Authorization")
+	authInfo = strings.TrimPrefix(authInfo, "Bearer ")
+
+	if authInfo == "" {
+		return config.SessionInfo{SessionKey: "", OrgID: ""}, fmt.Errorf("missing authorization header")
+	}
+
+	if strings.Contains(authInfo, ":") {
+		parts := strings.Split(authInfo, ":")
+		return config.SessionInfo{SessionKey: parts[0], OrgID: parts[1]}, nil
+	}
+
+	return config.SessionInfo{SessionKey: authInfo, OrgID: ""}, nil
+}
+
+// continueConversation 在之前的上游对话中只发送最后一条用户消息
+func continueConversation(c *gin.Context, previous *reusedConversation, reuseKey string, req *model.ChatCompletionRequest, model string, selector config.SessionSelector) bool {
+	session, ok := findPoolSession(previous.SessionKey, previous.OrgID)
+	if !ok || !selector.Matches(session) {
+		reusableConversations.forget(previous.ConversationID)
+		return false
+	}
+	logger.Info(fmt.Sprintf("Continuing conversation %s on session %s", previous.ConversationID, config.MaskSessionKey(session.SessionKey)))
+	processor := utils.NewChatRequestProcessor()
+	processor.FileOwner = keyOwner(c)
+	processor.Format = promptFormatFor(c, model)
+	processor.ProcessMessages(req.Messages[len(req.Messages)-1:])
+	if err := processor.DocumentError(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return true
+	}
+	processor.SetOutputLimits(req.StopSequences(), req.TokenLimit())
+	return handleChatRequest(c, session, model, processor, req.Stream, &reuseTarget{Key: reuseKey, Continue: previous})
+}
+
+// handleChatRequest 使用指定会话完成一次请求。reuse 非空时记录对话以便后续复用，
+// reuse.Continue 非空时在已有对话中继续
+func handleChatRequest(c *gin.Context, session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, stream bool, reuse *reuseTarget) bool {
+	session, proxy, claudeClient, err := prepareSession(session)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get org ID: %v", err))
+		return false
+	}
+	if caps, ok := config.GetSessionCapabilities(session.ID()); ok && !caps.SupportsModel(model) {
+		logger.Error(fmt.Sprintf("Session plan %s does not support model %s", caps.Plan, model))
+		return false
+	}
+
+	chat, err := buildChatRequest(claudeClient, session, proxy, processor)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to upload file: %v", err))
+		return false
+	}
+
+	// Create conversation, or continue the reused one
+	var conversationID string
+	if reuse != nil && reuse.Continue != nil {
+		conversationID = reuse.Continue.ConversationID
+		chat.ParentMessageUUID = reuse.Continue.MessageUUID
+	} else {
+		conversationID, err = claudeClient.CreateConversation(model)
+		reportUpstreamResult(session, proxy, err)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to create conversation: %v", err))
+			return false
+		}
+	}
+
+	// Send message
+	var completion *core.Completion
+	if processor.Output != nil {
+		completion, err = sendStructured(c, claudeClient, conversationID, chat, stream, processor.Output)
+	} else {
+		completion, err = claudeClient.SendMessage(conversationID, chat, stream, c)
+	}
+	var outputErr *invalidOutputError
+	if errors.As(err, &outputErr) {
+		// 换用其他会话也无法保证结果，直接返回错误
+		logger.Error(fmt.Sprintf("Structured output failed in conversation %s: %v", conversationID, err))
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if err != nil && reuse != nil && reuse.Continue != nil {
+		// 对话状态已不确定，不再复用
+		reusableConversations.forget(conversationID)
+	}
+
+	// 记录对话供后续请求复用，释放时再按 chatDelete 删除
+	// 截断的回复与上游对话中的内容不一致，不能复用
+	kept := false
+	if err == nil && reuse != nil {
+		if completion.MessageUUID != "" && !completion.Truncated {
+			reusableConversations.remember(reuse.Key, session, model, conversationID, completion.MessageUUID, completion.Text)
+			kept = true
+		} else if reuse.Continue != nil {
+			reusableConversations.forget(conversationID)
+			kept = true
+		}
+	}
+	return finishConversation(claudeClient, session, proxy, conversationID, chat, err, kept)
+}
+
+// finishConversation 按发送结果处理对话并返回是否不需要换用其他会话重试：
+// 客户端断开时停止上游生成，JSON 输出不符合要求时不再重试，其他错误上报后清理对话，
+// 成功时按 chatDelete 删除对话（kept 为 true 表示对话由复用记录保留）
+func finishConversation(claudeClient *core.Client, session config.SessionInfo, proxy, conversationID string, chat *core.ChatRequest, err error, kept bool) bool {
+	var outputErr *invalidOutputError
+	switch {
+	case errors.Is(err, core.ErrClientCanceled):
+		// 客户端已断开：停止上游生成，清理流程照常执行，且不再重试
+		logger.Info(fmt.Sprintf("Client disconnected, stopping conversation %s", conversationID))
+		go func() {
+			if err := claudeClient.StopResponse(conversationID); err != nil {
+				logger.Error(fmt.Sprintf("Failed to stop response of conversation %s: %v", conversationID, err))
+			}
+			if config.ConfigInstance.ChatDelete {
+				Cleanup.Enqueue(session, conversationID)
+			}
+		}()
+		return true
+	case errors.As(err, &outputErr):
+		if config.ConfigInstance.ChatDelete {
+			Cleanup.Enqueue(session, conversationID)
+		}
+		return true
+	case err != nil:
+		reportUpstreamResult(session, proxy, err)
+		logger.Error(fmt.Sprintf("Failed to send message: %v", err))
+		// 缓存的文件可能已失效，下次重新上传
+		core.Uploads.Forget(chat.Files)
+		Cleanup.Enqueue(session, conversationID)
+		return false
+	}
+
+	// Clean up conversation if enabled
+	if !kept && config.ConfigInstance.ChatDelete {
+		Cleanup.Enqueue(session, conversationID)
+	}
+	return true
+}
+
+// prepareSession 返回会话的客户端（绑定出口代理），尚未确定组织时先检测组织和账号能力
+func prepareSession(session config.SessionInfo) (config.SessionInfo, string, *core.Client, error) {
+	proxy := config.Proxies.ProxyFor(session)
+	claudeClient := sessionClient(session, proxy)
+	if session.OrgID == "" {
+		orgs, err := resolveSessionOrgs(claudeClient, session)
+		if err != nil {
+			reportUpstreamResult(session, proxy, err)
+			return session, proxy, nil, err
+		}
+		session.OrgID = orgs[0].UUID
+		claudeClient = sessionClient(session, proxy)
+	}
+	return session, proxy, claudeClient, nil
+}
+
+// buildChatRequest 上传图片和文件并处理超长上下文，返回本次请求的状态（与共享客户端分开保存）。
+// 文本文件作为附件发送，其他文件在图片之后按顺序上传
+func buildChatRequest(claudeClient *core.Client, session config.SessionInfo, proxy string, processor *utils.ChatRequestProcessor) (*core.ChatRequest, error) {
+	chat := &core.ChatRequest{}
+
+	// Upload images if any
+	if len(processor.ImgDataList) > 0 {
+		files, err := claudeClient.UploadFile(processor.ImgDataList)
+		if err != nil {
+			reportUpstreamResult(session, proxy, err)
+			return nil, err
+		}
+		chat.Files = files
+	}
+
+	var uploads []core.FileUpload
+	for _, doc := range processor.Documents {
+		if doc.IsText() {
+			for _, part := range doc.TextAttachments() {
+				chat.AddTextAttachment(part.Name, doc.AttachmentType(), part.Content)
+			}
+		} else {
+			uploads = append(uploads, doc.Upload())
+		}
+	}
+	if len(uploads) > 0 {
+		files, err := claudeClient.UploadFiles(uploads)
+		if err != nil {
+			reportUpstreamResult(session, proxy, err)
+			return nil, err
+		}
+		chat.Files = append(chat.Files, files...)
+	}
+
+	// Handle large context if needed
+	if files := processor.BuildContext(); len(files) > 0 {
+		for _, file := range files {
+			chat.SetBigContext(file.Name, file.Content)
+		}
+		logger.Info(fmt.Sprintf("Prompt exceeds %d tokens, sending %d context files", config.ConfigInstance.MaxChatHistoryTokens, len(files)))
+	}
+	chat.Prompt = processor.Prompt.String()
+	chat.Filter = processor.OutputFilter()
+	return chat, nil
+}
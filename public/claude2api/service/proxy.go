@@ -0,0 +1,85 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/metrics"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 单个代理健康检查的超时时间
+const proxyCheckTimeout = 15 * time.Second
+
+// StartProxyHealthChecker 启动后台协程，定期检查代理池中每个代理的连通性
+func StartProxyHealthChecker() {
+	interval := config.ConfigInstance.ProxyCheckInterval
+	if interval <= 0 || len(config.Proxies.List()) == 0 {
+		return
+	}
+	go func() {
+		for {
+			checkAllProxies()
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// checkAllProxies 并发检查所有代理并更新健康状态
+func checkAllProxies() {
+	var wg sync.WaitGroup
+	for _, proxy := range config.Proxies.List() {
+		wg.Add(1)
+		go func(proxy config.ProxyInfo) {
+			defer wg.Done()
+			err := core.CheckProxy(proxy.URL, config.ConfigInstance.ProxyCheckURL, proxyCheckTimeout)
+			if err != nil {
+				logger.Debug(fmt.Sprintf("Proxy %s check failed: %v", proxy.Name, err))
+			}
+			config.Proxies.ReportResult(proxy.Name, err)
+		}(proxy)
+	}
+	wg.Wait()
+}
+
+// reportUpstreamResult 根据上游请求的结果更新代理和会话状态：
+// 网络错误计入代理失败次数；Cloudflare 质询或拦截时让代理（不在代理池中时为会话）进入冷却
+func reportUpstreamResult(session config.SessionInfo, proxy string, err error) {
+	if err == nil {
+		if proxy != "" {
+			config.Proxies.RecordSuccess(proxy)
+		}
+		return
+	}
+	if core.IsNetworkError(err) {
+		metrics.Inc("claude2api_upstream_failures_total", "kind", "network")
+		if proxy != "" {
+			config.Proxies.RecordFailure(proxy, err)
+		}
+		return
+	}
+
+	kind := core.FailureKind(err)
+	if kind == "" {
+		return
+	}
+	metrics.Inc("claude2api_upstream_failures_total", "kind", kind)
+	if !core.IsEgressBlocked(err) {
+		return
+	}
+	cooldown := config.ConfigInstance.BlockCooldownDuration()
+	if proxy != "" && config.Proxies.Block(proxy, err.Error(), cooldown) {
+		metrics.Inc("claude2api_egress_blocks_total", "target", "proxy", "kind", kind)
+		return
+	}
+	// 没有代理池时其他会话也使用同一出口，整个出口进入冷却
+	if _, ok := config.Proxies.DirectEgress(session); ok {
+		config.BlockEgress(proxy, err.Error(), cooldown)
+		metrics.Inc("claude2api_egress_blocks_total", "target", "egress", "kind", kind)
+		return
+	}
+	config.BlockSession(session.ID(), err.Error(), cooldown)
+	metrics.Inc("claude2api_egress_blocks_total", "target", "session", "kind", kind)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"claude2api/utils"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// reusedConversation 记录一次成功请求所在的上游对话，后续请求延续同一消息列表时可直接追加
+type reusedConversation struct {
+	SessionKey     string
+	OrgID          string
+	Model          string
+	ConversationID string
+	// MessageUUID 上游助手回复的消息 UUID，作为下一轮的 parent_message_uuid
+	MessageUUID string
+	// Reply 规范化后的助手回复，客户端回传的内容必须与之一致
+	Reply     string
+	UpdatedAt time.Time
+}
+
+// reuseTarget 描述一次请求与对话复用的关系
+type reuseTarget struct {
+	// Key 本次请求完整消息列表的摘要，成功后以此记录对话
+	Key string
+	// Continue 非空时在该对话中继续，而不是新建对话
+	Continue *reusedConversation
+}
+
+// conversationStore 按消息列表摘要记录可复用的上游对话
+type conversationStore struct {
+	mu      sync.Mutex
+	entries map[string]*reusedConversation
+}
+
+var reusableConversations = &conversationStore{entries: map[string]*reusedConversation{}}
+
+// StartConversationReuse 启动后台协程，定期释放过期的复用对话
+func StartConversationReuse() {
+	if !config.ConfigInstance.ConversationReuse {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			reusableConversations.expire()
+		}
+	}()
+}
+
+// match 查找请求可以延续的对话：消息列表以 之前的消息 + 助手回复 + 新的用户消息 结尾，
+// 且之前的消息列表和助手回复与记录一致。scope 见 reuseScope。返回本次请求的摘要，未启用复用时为空
+func (s *conversationStore) match(scope string, messages []map[string]interface{}, model string) (string, *reusedConversation) {
+	if !config.ConfigInstance.ConversationReuse {
+		return "", nil
+	}
+	key := scope + utils.TranscriptHash(messages)
+	n := len(messages)
+	if n < 3 || messages[n-1]["role"] != "user" || messages[n-2]["role"] != "assistant" {
+		return key, nil
+	}
+	s.expire()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[scope+utils.TranscriptHash(messages[:n-2])]
+	if !ok || entry.Model != model || entry.Reply != utils.NormalizeReply(utils.MessageText(messages[n-2])) {
+		return key, nil
+	}
+	found := *entry
+	return key, &found
+}
+
+// reuseScope 返回记录的范围：对话只在同一 API Key 和提示词格式下复用，
+// 不同 Key 发送相同的消息列表时不会延续其他 Key 的会话、文件和格式
+func reuseScope(owner string, format *utils.PromptFormat) string {
+	if format == nil {
+		format = utils.DefaultPromptFormat()
+	}
+	return owner + "\x00" + format.Name + "\x00"
+}
+
+// remember 记录对话的最新状态，同一对话之前的记录被替换
+func (s *conversationStore) remember(key string, session config.SessionInfo, model, conversationID, messageUUID, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, entry := range s.entries {
+		if entry.ConversationID == conversationID {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = &reusedConversation{
+		SessionKey:     session.SessionKey,
+		OrgID:          session.OrgID,
+		Model:          model,
+		ConversationID: conversationID,
+		MessageUUID:    messageUUID,
+		Reply:          utils.NormalizeReply(reply),
+		UpdatedAt:      time.Now(),
+	}
+}
+
+// holds 判断对话是否正在等待复用，清扫时需要跳过
+func (s *conversationStore) holds(conversationID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.ConversationID == conversationID {
+			return true
+		}
+	}
+	return false
+}
+
+// forget 不再复用对话，按 chatDelete 配置删除
+func (s *conversationStore) forget(conversationID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, entry := range s.entries {
+		if entry.ConversationID == conversationID {
+			delete(s.entries, k)
+			s.release(entry)
+		}
+	}
+}
+
+// expire 释放超过 conversationReuseTTL 未使用的对话
+func (s *conversationStore) expire() {
+	cutoff := time.Now().Add(-time.Duration(config.ConfigInstance.ConversationReuseTTL) * time.Minute)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, entry := range s.entries {
+		if entry.UpdatedAt.Before(cutoff) {
+			delete(s.entries, k)
+			s.release(entry)
+		}
+	}
+}
+
+// release 对话不再被复用后按 chatDelete 配置加入删除队列，调用方需持有 s.mu
+func (s *conversationStore) release(entry *reusedConversation) {
+	logger.Info(fmt.Sprintf("Released reused conversation %s", entry.ConversationID))
+	if config.ConfigInstance.ChatDelete {
+		Cleanup.Enqueue(config.SessionInfo{SessionKey: entry.SessionKey, OrgID: entry.OrgID}, entry.ConversationID)
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/utils"
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invalidOutputError 重试后回复仍不符合 response_format
+type invalidOutputError struct {
+	attempts int
+	err      error
+}
+
+func (e *invalidOutputError) Error() string {
+	return fmt.Sprintf("reply did not match response_format after %d attempts: %v", e.attempts, e.err)
+}
+
+func (e *invalidOutputError) Unwrap() error {
+	return e.err
+}
+
+// sendStructured 发送消息并等待完整回复，取出并校验其中的 JSON 后返回给客户端。
+// 校验失败时在同一对话中附上错误原因要求重新回复，最多重试 jsonRetries 次
+func sendStructured(c *gin.Context, claudeClient *core.Client, conversationID string, chat *core.ChatRequest, stream bool, output *utils.JSONOutput) (*core.Completion, error) {
+	completion, err := completeStructured(c.Request.Context(), claudeClient, conversationID, chat, output)
+	if err != nil {
+		return nil, err
+	}
+	core.WriteResponse(completion.Text, stream, c)
+	return completion, nil
+}
+
+// completeStructured 与 sendStructured 相同，但不写入响应
+func completeStructured(ctx context.Context, claudeClient *core.Client, conversationID string, chat *core.ChatRequest, output *utils.JSONOutput) (*core.Completion, error) {
+	attempts := 1
+	if config.ConfigInstance.JSONRetries > 0 {
+		attempts += config.ConfigInstance.JSONRetries
+	}
+	for attempt := 1; ; attempt++ {
+		completion, err := claudeClient.Complete(ctx, conversationID, chat)
+		if err != nil {
+			return nil, err
+		}
+		text, err := output.Extract(utils.NormalizeReply(completion.Text))
+		if err == nil {
+			return &core.Completion{MessageUUID: completion.MessageUUID, Text: text, FinishReason: "stop"}, nil
+		}
+		if attempt >= attempts {
+			return nil, &invalidOutputError{attempts: attempts, err: err}
+		}
+		logger.Info(fmt.Sprintf("Reply does not match response_format (attempt %d/%d): %v", attempt, attempts, err))
+		chat = &core.ChatRequest{
+			Prompt:            output.Correction(err),
+			ParentMessageUUID: completion.MessageUUID,
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StaleConversation 清扫时发现的遗留对话
+type StaleConversation struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SessionSweep 单个会话的清扫结果
+type SessionSweep struct {
+	Session string              `json:"session"`
+	Scanned int                 `json:"scanned"`
+	Stale   []StaleConversation `json:"stale"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// SweepReport 一次清扫的报告，DryRun 时只列出遗留对话而不删除
+type SweepReport struct {
+	StartedAt     time.Time      `json:"startedAt"`
+	DryRun        bool           `json:"dryRun"`
+	MaxAgeMinutes int            `json:"maxAgeMinutes"`
+	Queued        int            `json:"queued"`
+	Sessions      []SessionSweep `json:"sessions"`
+}
+
+var (
+	lastSweep   *SweepReport
+	lastSweepMu sync.Mutex
+	// 防止定时清扫与手动清扫同时进行
+	sweepRunning sync.Mutex
+)
+
+// StartConversationSweeper 启动后台协程，定期删除代理创建且长时间未更新的对话
+func StartConversationSweeper() {
+	interval := config.ConfigInstance.SweepInterval
+	if interval <= 0 {
+		logger.Info("Stale conversation sweeper is disabled")
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Minute)
+			sweepConversations(config.ConfigInstance.SweepDryRun)
+		}
+	}()
+}
+
+// sweepConversations 列出每个会话的对话，将代理创建且闲置超过 sweepMaxAgeMinutes 的对话加入删除队列
+func sweepConversations(dryRun bool) SweepReport {
+	sweepRunning.Lock()
+	defer sweepRunning.Unlock()
+
+	maxAge := config.ConfigInstance.SweepMaxAge
+	report := SweepReport{
+		StartedAt:     time.Now(),
+		DryRun:        dryRun,
+		MaxAgeMinutes: maxAge,
+		Sessions:      []SessionSweep{},
+	}
+	cutoff := report.StartedAt.Add(-time.Duration(maxAge) * time.Minute)
+
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := append([]config.SessionInfo{}, config.ConfigInstance.Sessions...)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	for _, session := range sessions {
+		// 尚未确定组织的会话由能力检测展开后再清扫
+		if session.OrgID == "" {
+			continue
+		}
+		result := SessionSweep{Session: maskSessionID(session), Stale: []StaleConversation{}}
+		proxy := config.Proxies.ProxyFor(session)
+		conversations, err := sessionClient(session, proxy).ListConversations()
+		reportUpstreamResult(session, proxy, err)
+		if err != nil {
+			result.Error = err.Error()
+			logger.Error(fmt.Sprintf("Failed to list conversations of session %s: %v", result.Session, err))
+			report.Sessions = append(report.Sessions, result)
+			continue
+		}
+		result.Scanned = len(conversations)
+		for _, conversation := range conversations {
+			if !conversation.IsProxyOwned() && !(config.ConfigInstance.SweepUntitled && conversation.Name == "") {
+				continue
+			}
+			updatedAt := conversation.UpdatedAt
+			if updatedAt.IsZero() {
+				updatedAt = conversation.CreatedAt
+			}
+			// 线程使用的对话由线程删除时处理，复用中的对话在释放时处理
+			if updatedAt.After(cutoff) || Threads.OwnsConversation(conversation.UUID) || reusableConversations.holds(conversation.UUID) {
+				continue
+			}
+			result.Stale = append(result.Stale, StaleConversation{
+				UUID:      conversation.UUID,
+				Name:      conversation.Name,
+				UpdatedAt: updatedAt,
+			})
+			if !dryRun {
+				Cleanup.Enqueue(session, conversation.UUID)
+				report.Queued++
+			}
+		}
+		if len(result.Stale) > 0 {
+			if dryRun {
+				logger.Info(fmt.Sprintf("[dry run] Session %s has %d stale conversations", result.Session, len(result.Stale)))
+			} else {
+				logger.Info(fmt.Sprintf("Session %s: queued %d stale conversations for deletion", result.Session, len(result.Stale)))
+			}
+		}
+		report.Sessions = append(report.Sessions, result)
+	}
+
+	lastSweepMu.Lock()
+	lastSweep = &report
+	lastSweepMu.Unlock()
+	return report
+}
+
+// AdminLastSweepHandler 返回最近一次清扫的报告
+func AdminLastSweepHandler(c *gin.Context) {
+	lastSweepMu.Lock()
+	report := lastSweep
+	lastSweepMu.Unlock()
+	if report == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "No sweep has run yet",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// AdminSweepHandler 立即执行一次清扫，?dry_run=true 时只返回报告
+func AdminSweepHandler(c *gin.Context) {
+	dryRun := config.ConfigInstance.SweepDryRun
+	if value := c.Query("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid dry_run value",
+			})
+			return
+		}
+		dryRun = parsed
+	}
+	c.JSON(http.StatusOK, sweepConversations(dryRun))
+}
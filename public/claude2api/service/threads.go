@@ -0,0 +1,429 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/utils"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// threadObject 线程的 OpenAI 风格表示
+type threadObject struct {
+	ID        string            `json:"id"`
+	Object    string            `json:"object"`
+	CreatedAt int64             `json:"created_at"`
+	Model     string            `json:"model"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+// threadMessageObject 线程消息的 OpenAI 风格表示
+type threadMessageObject struct {
+	ID        string      `json:"id"`
+	Object    string      `json:"object"`
+	CreatedAt int64       `json:"created_at"`
+	ThreadID  string      `json:"thread_id"`
+	Role      string      `json:"role"`
+	Content   interface{} `json:"content"`
+}
+
+type createThreadRequest struct {
+	Model    string                   `json:"model"`
+	Metadata map[string]string        `json:"metadata"`
+	Messages []map[string]interface{} `json:"messages"`
+}
+
+type createThreadMessageRequest struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type createThreadRunRequest struct {
+	Stream bool `json:"stream"`
+}
+
+func newThreadObject(thread Thread) threadObject {
+	metadata := thread.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return threadObject{
+		ID:        thread.ID,
+		Object:    "thread",
+		CreatedAt: thread.CreatedAt.Unix(),
+		Model:     thread.Model,
+		Metadata:  metadata,
+	}
+}
+
+func newThreadMessageObject(threadID string, msg ThreadMessage) threadMessageObject {
+	return threadMessageObject{
+		ID:        msg.ID,
+		Object:    "thread.message",
+		CreatedAt: msg.CreatedAt.Unix(),
+		ThreadID:  threadID,
+		Role:      msg.Role,
+		Content:   msg.Content,
+	}
+}
+
+// keyOwner 线程和文件归属于创建它们的 API Key 策略，主 APIKEY 创建的归属为空
+func keyOwner(c *gin.Context) string {
+	if policy := apiKeyPolicy(c); policy != nil {
+		if policy.Name != "" {
+			return policy.Name
+		}
+		return fmt.Sprintf("key:%x", sha256.Sum256([]byte(policy.Key)))[:20]
+	}
+	return ""
+}
+
+// newUserMessage 校验并创建一条待发送的用户消息
+func newUserMessage(role string, content interface{}) (ThreadMessage, error) {
+	if role != "user" {
+		return ThreadMessage{}, fmt.Errorf("only user messages can be added to a thread")
+	}
+	switch content.(type) {
+	case string, []interface{}:
+	default:
+		return ThreadMessage{}, fmt.Errorf("content must be a string or an array of content parts")
+	}
+	return ThreadMessage{
+		ID:        "msg_" + uuid.New().String(),
+		Role:      role,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// CreateThreadHandler 创建线程并固定一个会话
+func CreateThreadHandler(c *gin.Context) {
+	var req createThreadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	model := getModelOrDefault(req.Model)
+
+	var messages []ThreadMessage
+	for _, m := range req.Messages {
+		role, _ := m["role"].(string)
+		msg, err := newUserMessage(role, m["content"])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: fmt.Sprintf("Invalid message: %v", err),
+			})
+			return
+		}
+		messages = append(messages, msg)
+	}
+
+	// 不计入共享的重试次数，避免影响同时进行的聊天请求
+	session, err := config.Sr.NextSession(buildSessionSelector(c, model))
+	if err != nil {
+		logger.Error(fmt.Sprintf("No session available for thread: %v", err))
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: fmt.Sprintf("No session available: %v", err),
+		})
+		return
+	}
+
+	now := time.Now()
+	thread := &Thread{
+		ID:         "thread_" + uuid.New().String(),
+		Owner:      keyOwner(c),
+		Model:      model,
+		Metadata:   req.Metadata,
+		SessionKey: session.SessionKey,
+		OrgID:      session.OrgID,
+		Messages:   messages,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	Threads.Create(thread)
+	logger.Info(fmt.Sprintf("Created thread %s on session %s", thread.ID, config.MaskSessionKey(session.SessionKey)))
+	c.JSON(http.StatusOK, newThreadObject(*thread))
+}
+
+// GetThreadHandler 返回线程信息
+func GetThreadHandler(c *gin.Context) {
+	thread, ok := Threads.Get(c.Param("thread_id"), keyOwner(c))
+	if !ok {
+		threadNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, newThreadObject(thread))
+}
+
+// DeleteThreadHandler 删除线程，启用 chatDelete 时同时删除上游对话
+func DeleteThreadHandler(c *gin.Context) {
+	threadID := c.Param("thread_id")
+	// 先确认线程属于当前 API Key，其他 Key 无法占用或探测该线程
+	if _, ok := Threads.Get(threadID, keyOwner(c)); !ok {
+		threadNotFound(c)
+		return
+	}
+	if !Threads.Acquire(threadID) {
+		threadBusy(c)
+		return
+	}
+	defer Threads.Release(threadID)
+
+	thread, ok := Threads.Delete(threadID, keyOwner(c))
+	if !ok {
+		threadNotFound(c)
+		return
+	}
+	if thread.ConversationID != "" && config.ConfigInstance.ChatDelete {
+		Cleanup.Enqueue(config.SessionInfo{SessionKey: thread.SessionKey, OrgID: thread.OrgID}, thread.ConversationID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      thread.ID,
+		"object":  "thread.deleted",
+		"deleted": true,
+	})
+}
+
+// CreateThreadMessageHandler 向线程追加一条用户消息，下次运行时发送
+func CreateThreadMessageHandler(c *gin.Context) {
+	var req createThreadMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	msg, err := newUserMessage(req.Role, req.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid message: %v", err),
+		})
+		return
+	}
+	threadID := c.Param("thread_id")
+	if !Threads.Update(threadID, keyOwner(c), func(thread *Thread) {
+		thread.Messages = append(thread.Messages, msg)
+	}) {
+		threadNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, newThreadMessageObject(threadID, msg))
+}
+
+// ListThreadMessagesHandler 按时间顺序列出线程中的消息，?order=desc 时倒序
+func ListThreadMessagesHandler(c *gin.Context) {
+	thread, ok := Threads.Get(c.Param("thread_id"), keyOwner(c))
+	if !ok {
+		threadNotFound(c)
+		return
+	}
+	data := make([]threadMessageObject, 0, len(thread.Messages))
+	for _, msg := range thread.Messages {
+		data = append(data, newThreadMessageObject(thread.ID, msg))
+	}
+	if c.Query("order") == "desc" {
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// CreateThreadRunHandler 将线程中尚未发送的用户消息发送到上游对话，
+// 以 chat completions 格式（可流式）返回回复，并把回复保存到线程中
+func CreateThreadRunHandler(c *gin.Context) {
+	var req createThreadRunRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+	}
+	threadID := c.Param("thread_id")
+	owner := keyOwner(c)
+	if _, ok := Threads.Get(threadID, owner); !ok {
+		threadNotFound(c)
+		return
+	}
+	if !Threads.Acquire(threadID) {
+		threadBusy(c)
+		return
+	}
+	defer Threads.Release(threadID)
+
+	thread, ok := Threads.Get(threadID, owner)
+	if !ok {
+		threadNotFound(c)
+		return
+	}
+	var pending []map[string]interface{}
+	sent := map[string]bool{}
+	for _, msg := range thread.Messages {
+		if msg.Role == "user" && !msg.Sent {
+			pending = append(pending, map[string]interface{}{"role": msg.Role, "content": cloneContent(msg.Content)})
+			sent[msg.ID] = true
+		}
+	}
+	if len(pending) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Thread has no new user messages to run",
+		})
+		return
+	}
+
+	if err := utils.PrepareImages(c.Request.Context(), pending); err != nil {
+		logger.Error(fmt.Sprintf("Failed to prepare images for thread %s: %v", threadID, err))
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid image: %v", err),
+		})
+		return
+	}
+
+	session, ok := findPoolSession(thread.SessionKey, thread.OrgID)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: "The session of this thread is no longer available",
+		})
+		return
+	}
+	session, proxy, claudeClient, err := prepareSession(session)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get org ID for thread %s: %v", threadID, err))
+		threadUpstreamError(c, err)
+		return
+	}
+
+	processor := utils.NewChatRequestProcessor()
+	processor.FileOwner = owner
+	processor.Format = promptFormatFor(c, thread.Model)
+	processor.ProcessMessages(pending)
+	if err := processor.DocumentError(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid file: %v", err),
+		})
+		return
+	}
+	if !applyContextStrategy(c, processor, thread.Model) {
+		return
+	}
+	chat, err := buildChatRequest(claudeClient, session, proxy, processor)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to upload file for thread %s: %v", threadID, err))
+		threadUpstreamError(c, err)
+		return
+	}
+
+	// 首次运行时创建上游对话并立即保存，保证删除线程时能找到它
+	conversationID := thread.ConversationID
+	created := false
+	if conversationID == "" {
+		conversationID, err = claudeClient.CreateConversation(thread.Model)
+		reportUpstreamResult(session, proxy, err)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to create conversation for thread %s: %v", threadID, err))
+			threadUpstreamError(c, err)
+			return
+		}
+		created = true
+		Threads.Update(threadID, owner, func(t *Thread) {
+			t.OrgID = session.OrgID
+			t.ConversationID = conversationID
+		})
+	}
+	chat.ParentMessageUUID = thread.LastMessageUUID
+
+	completion, err := claudeClient.SendMessage(conversationID, chat, req.Stream, c)
+	if errors.Is(err, core.ErrClientCanceled) {
+		// 消息保持未发送状态，下次运行从上一条回复重新开始
+		logger.Info(fmt.Sprintf("Client disconnected, stopping thread %s", threadID))
+		go func() {
+			if err := claudeClient.StopResponse(conversationID); err != nil {
+				logger.Error(fmt.Sprintf("Failed to stop response of conversation %s: %v", conversationID, err))
+			}
+		}()
+		return
+	}
+	if err != nil {
+		reportUpstreamResult(session, proxy, err)
+		logger.Error(fmt.Sprintf("Failed to run thread %s: %v", threadID, err))
+		core.Uploads.Forget(chat.Files)
+		if created {
+			Cleanup.Enqueue(session, conversationID)
+			Threads.Update(threadID, owner, func(t *Thread) {
+				t.ConversationID = ""
+			})
+		}
+		threadUpstreamError(c, err)
+		return
+	}
+
+	if completion.MessageUUID == "" {
+		logger.Warn(fmt.Sprintf("No message UUID in response of thread %s, the next run continues from the previous reply", threadID))
+	}
+	Threads.Update(threadID, owner, func(t *Thread) {
+		for i := range t.Messages {
+			if sent[t.Messages[i].ID] {
+				t.Messages[i].Sent = true
+			}
+		}
+		t.Messages = append(t.Messages, ThreadMessage{
+			ID:        "msg_" + uuid.New().String(),
+			Role:      "assistant",
+			Content:   completion.Text,
+			CreatedAt: time.Now(),
+		})
+		if completion.MessageUUID != "" {
+			t.LastMessageUUID = completion.MessageUUID
+		}
+	})
+}
+
+// cloneContent 复制消息内容，处理图片时不修改保存的线程
+func cloneContent(content interface{}) interface{} {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return content
+	}
+	var cloned interface{}
+	if err := json.Unmarshal(data, &cloned); err != nil {
+		return content
+	}
+	return cloned
+}
+
+func threadNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: "Thread not found",
+	})
+}
+
+func threadBusy(c *gin.Context) {
+	c.JSON(http.StatusConflict, ErrorResponse{
+		Error: "Thread has an active run",
+	})
+}
+
+// threadUpstreamError 在尚未开始输出时返回上游错误
+func threadUpstreamError(c *gin.Context, err error) {
+	if c.Writer.Written() {
+		return
+	}
+	c.JSON(http.StatusBadGateway, ErrorResponse{
+		Error: fmt.Sprintf("Upstream request failed: %v", err),
+	})
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const threadsFile = "threads.json"
+
+// ThreadMessage 线程中的一条消息，Content 为字符串或 OpenAI 格式的内容数组
+type ThreadMessage struct {
+	ID        string      `json:"id"`
+	Role      string      `json:"role"`
+	Content   interface{} `json:"content"`
+	CreatedAt time.Time   `json:"createdAt"`
+	// Sent 用户消息是否已发送到上游对话
+	Sent bool `json:"sent,omitempty"`
+}
+
+// Thread 服务端保存的会话线程，对应固定会话上的一个 claude.ai 对话
+type Thread struct {
+	ID       string            `json:"id"`
+	Owner    string            `json:"owner"`
+	Model    string            `json:"model"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// 固定使用的会话
+	SessionKey string `json:"sessionKey"`
+	OrgID      string `json:"orgID"`
+	// 上游对话，首次运行时创建
+	ConversationID  string          `json:"conversationID,omitempty"`
+	LastMessageUUID string          `json:"lastMessageUUID,omitempty"`
+	Messages        []ThreadMessage `json:"messages"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+// ThreadStore 持久化到数据目录的线程列表
+type ThreadStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	threads map[string]*Thread
+	// 正在运行的线程，同一线程同时只能有一次运行
+	running map[string]bool
+}
+
+// Threads 全局线程存储
+var Threads = &ThreadStore{threads: map[string]*Thread{}, running: map[string]bool{}}
+
+// load 首次使用时从数据目录读取线程，调用方需持有 s.mu
+func (s *ThreadStore) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	if err := config.LoadDataFile(threadsFile, &s.threads); err != nil {
+		logger.Error(fmt.Sprintf("Failed to load threads: %v", err))
+	}
+	if s.threads == nil {
+		s.threads = map[string]*Thread{}
+	}
+}
+
+// save 持久化线程，调用方需持有 s.mu
+func (s *ThreadStore) save() {
+	if err := config.SaveDataFile(threadsFile, s.threads); err != nil {
+		logger.Error(fmt.Sprintf("Failed to save threads: %v", err))
+	}
+}
+
+// Create 保存新线程
+func (s *ThreadStore) Create(thread *Thread) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	s.threads[thread.ID] = thread
+	s.save()
+}
+
+// Get 返回属于 owner 的线程副本
+func (s *ThreadStore) Get(id, owner string) (Thread, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	thread, ok := s.threads[id]
+	if !ok || thread.Owner != owner {
+		return Thread{}, false
+	}
+	copied := *thread
+	copied.Messages = append([]ThreadMessage{}, thread.Messages...)
+	return copied, true
+}
+
+// Update 在锁内修改属于 owner 的线程并持久化
+func (s *ThreadStore) Update(id, owner string, fn func(thread *Thread)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	thread, ok := s.threads[id]
+	if !ok || thread.Owner != owner {
+		return false
+	}
+	fn(thread)
+	thread.UpdatedAt = time.Now()
+	s.save()
+	return true
+}
+
+// Delete 删除属于 owner 的线程并返回被删除的线程
+func (s *ThreadStore) Delete(id, owner string) (Thread, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	thread, ok := s.threads[id]
+	if !ok || thread.Owner != owner {
+		return Thread{}, false
+	}
+	delete(s.threads, id)
+	s.save()
+	return *thread, true
+}
+
+// Acquire 标记线程正在运行，已在运行时返回 false
+func (s *ThreadStore) Acquire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return false
+	}
+	s.running[id] = true
+	return true
+}
+
+// Release 结束线程的运行
+func (s *ThreadStore) Release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, id)
+}
+
+// OwnsConversation 判断上游对话是否属于某个线程，清扫时需要跳过
+func (s *ThreadStore) OwnsConversation(conversationID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	for _, thread := range s.threads {
+		if thread.ConversationID == conversationID {
+			return true
+		}
+	}
+	return false
+}
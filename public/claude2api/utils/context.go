@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"claude2api/config"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// promptTurn 一条消息在提示词中的文本（含角色前缀）及其包含的文件和图片
+type promptTurn struct {
+	Role   string
+	Text   string
+	Docs   []Document
+	Images []string
+}
+
+// ContextFile 作为文本附件发送的上下文
+type ContextFile struct {
+	Name    string
+	Content string
+}
+
+// ContextLengthError 请求超出模型的上下文上限
+type ContextLengthError struct {
+	Model  string
+	Tokens int
+	Limit  int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("request is about %d tokens, which exceeds the %d token context window of %s", e.Tokens, e.Limit, e.Model)
+}
+
+// EstimatedTokens 估算请求的 token 数，包括提示词和文本文件
+func (p *ChatRequestProcessor) EstimatedTokens() int {
+	tokens := EstimateTokens(p.RootPrompt.String())
+	for _, doc := range p.Documents {
+		if doc.IsText() {
+			tokens += EstimateTokens(string(doc.Data))
+		}
+	}
+	return tokens
+}
+
+// CheckContextWindow 请求超出模型的上下文上限时返回 *ContextLengthError
+func (p *ChatRequestProcessor) CheckContextWindow(model string) error {
+	limit := config.ConfigInstance.ContextWindowFor(model)
+	if tokens := p.EstimatedTokens(); tokens > limit {
+		return &ContextLengthError{Model: model, Tokens: tokens, Limit: limit}
+	}
+	return nil
+}
+
+// BuildContext 提示词超过 maxChatHistoryTokens 时，把系统消息和较早的消息分别转为附件
+// （超过 contextFileTokens 时拆分为多个），最近 contextInlineTurns 条消息保留在提示词中，
+// 并相应地重写 Prompt。提示词未超过阈值时返回 nil
+func (p *ChatRequestProcessor) BuildContext() []ContextFile {
+	if EstimateTokens(p.Prompt.String()) <= config.ConfigInstance.MaxChatHistoryTokens {
+		return nil
+	}
+
+	// 从后往前保留最近的非系统消息
+	inline := make([]bool, len(p.turns))
+	for i, kept := len(p.turns)-1, 0; i >= 0 && kept < config.ConfigInstance.ContextInlineTurns; i-- {
+		if p.turns[i].Role != "system" {
+			inline[i] = true
+			kept++
+		}
+	}
+	var system, history, latest strings.Builder
+	for i, turn := range p.turns {
+		switch {
+		case inline[i]:
+			latest.WriteString(turn.Text)
+		case turn.Role == "system":
+			system.WriteString(turn.Text)
+		default:
+			history.WriteString(turn.Text)
+		}
+	}
+	// 只有最近的消息时（例如一条很长的消息），与之前一样全部作为 context.txt 发送
+	historyName := "history.txt"
+	if system.Len() == 0 && history.Len() == 0 {
+		history.WriteString(latest.String())
+		latest.Reset()
+		historyName = "context.txt"
+	}
+
+	maxTokens := config.ConfigInstance.ContextFileTokens
+	var files []ContextFile
+	files = append(files, SplitContextFile("system.txt", system.String(), maxTokens)...)
+	files = append(files, SplitContextFile(historyName, history.String(), maxTokens)...)
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Name
+	}
+
+	p.Prompt.Reset()
+	p.Prompt.WriteString(p.header())
+	p.Prompt.WriteString(p.format().BigContext(names, latest.Len() > 0))
+	p.Prompt.WriteString(latest.String())
+	p.Prompt.WriteString(p.instructions)
+	return files
+}
+
+// TextAttachments 将文本文件按 contextFileTokens 拆分为一个或多个附件
+func (d Document) TextAttachments() []ContextFile {
+	return SplitContextFile(d.Name, string(d.Data), config.ConfigInstance.ContextFileTokens)
+}
+
+// SplitContextFile 按 token 数拆分文本，拆分后的文件名为 name-1.ext、name-2.ext 等，文本为空时返回 nil
+func SplitContextFile(name, content string, maxTokens int) []ContextFile {
+	if content == "" {
+		return nil
+	}
+	chunks := SplitByTokens(content, maxTokens)
+	if len(chunks) == 1 {
+		return []ContextFile{{Name: name, Content: content}}
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	files := make([]ContextFile, len(chunks))
+	for i, chunk := range chunks {
+		files[i] = ContextFile{Name: fmt.Sprintf("%s-%d%s", base, i+1, ext), Content: chunk}
+	}
+	return files
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/core"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document 请求中附带的文件。文本文件作为附件（extracted_content）发送，其他文件上传
+type Document struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// 按扩展名识别为文本的文件（源代码、配置等）
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".tsv": true, ".json": true, ".jsonl": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".log": true,
+	".html": true, ".htm": true, ".css": true, ".js": true, ".ts": true, ".jsx": true,
+	".tsx": true, ".py": true, ".go": true, ".java": true, ".c": true, ".h": true,
+	".cpp": true, ".hpp": true, ".cs": true, ".rs": true, ".rb": true, ".php": true,
+	".sh": true, ".sql": true, ".swift": true, ".kt": true, ".scala": true, ".lua": true,
+}
+
+// IsText 判断文件是否按文本附件发送
+func (d Document) IsText() bool {
+	contentType := d.ContentType
+	switch {
+	case strings.HasPrefix(contentType, "text/"),
+		contentType == "application/json",
+		contentType == "application/xml",
+		contentType == "application/x-yaml",
+		contentType == "application/javascript":
+		return utf8.Valid(d.Data)
+	}
+	if textExtensions[strings.ToLower(filepath.Ext(d.Name))] {
+		return utf8.Valid(d.Data)
+	}
+	// 未声明类型的文件，内容是不含 NUL 的 UTF-8 时按文本处理
+	if contentType == "" || contentType == "application/octet-stream" {
+		return utf8.Valid(d.Data) && bytes.IndexByte(d.Data, 0) < 0
+	}
+	return false
+}
+
+// AttachmentType 文本附件的 file_type，未声明为 text/* 的文本文件使用 text/plain
+func (d Document) AttachmentType() string {
+	if strings.HasPrefix(d.ContentType, "text/") {
+		return d.ContentType
+	}
+	return "text/plain"
+}
+
+// Upload 转换为上传请求
+func (d Document) Upload() core.FileUpload {
+	return core.FileUpload{Name: d.Name, ContentType: d.ContentType, Data: d.Data}
+}
+
+// documentFromDataURI 解析 data URI 形式的文件内容
+func documentFromDataURI(name, dataURI string) (Document, error) {
+	if !strings.HasPrefix(dataURI, "data:") {
+		// 部分客户端只发送 base64 内容
+		data, err := base64.StdEncoding.DecodeString(dataURI)
+		if err != nil {
+			return Document{}, errors.New("file_data must be a base64 data URI")
+		}
+		return Document{Name: name, ContentType: contentTypeByName(name), Data: data}, nil
+	}
+	contentType, data, err := DecodeDataURI(dataURI)
+	if err != nil {
+		return Document{}, err
+	}
+	if contentType == "" {
+		contentType = contentTypeByName(name)
+	}
+	return Document{Name: name, ContentType: contentType, Data: data}, nil
+}
+
+func contentTypeByName(name string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// parseOpenAIFile 解析 OpenAI 的 file 内容：{"type": "file", "file": {"filename": "...", "file_data": "data:..."}}，
+// file_id 引用通过 /v1/files 上传的、属于 owner 的文件
+func parseOpenAIFile(item map[string]interface{}, owner string) (Document, error) {
+	file, ok := item["file"].(map[string]interface{})
+	if !ok {
+		return Document{}, errors.New("missing file object")
+	}
+	name, _ := file["filename"].(string)
+	if fileData, ok := file["file_data"].(string); ok && fileData != "" {
+		return documentFromDataURI(name, fileData)
+	}
+	if fileID, ok := file["file_id"].(string); ok && fileID != "" {
+		stored, data, err := Files.Open(fileID, owner)
+		if errors.Is(err, ErrFileNotFound) {
+			return Document{}, fmt.Errorf("file_id %s not found", fileID)
+		}
+		if err != nil {
+			return Document{}, err
+		}
+		if name == "" {
+			name = stored.Filename
+		}
+		return Document{Name: name, ContentType: stored.ContentType, Data: data}, nil
+	}
+	return Document{}, errors.New("file has no file_data")
+}
+
+// parseAnthropicDocument 解析 Anthropic 的 document 内容块，source 支持 base64、text 和 content
+func parseAnthropicDocument(item map[string]interface{}) (Document, error) {
+	source, ok := item["source"].(map[string]interface{})
+	if !ok {
+		return Document{}, errors.New("missing document source")
+	}
+	name, _ := item["title"].(string)
+	mediaType, _ := source["media_type"].(string)
+	switch source["type"] {
+	case "base64":
+		encoded, _ := source["data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return Document{}, fmt.Errorf("failed to decode base64 data: %w", err)
+		}
+		return Document{Name: name, ContentType: mediaType, Data: data}, nil
+	case "text":
+		text, _ := source["data"].(string)
+		if mediaType == "" {
+			mediaType = "text/plain"
+		}
+		return Document{Name: name, ContentType: mediaType, Data: []byte(text)}, nil
+	case "content":
+		var parts []string
+		if blocks, ok := source["content"].([]interface{}); ok {
+			for _, block := range blocks {
+				if blockMap, ok := block.(map[string]interface{}); ok && blockMap["type"] == "text" {
+					if text, ok := blockMap["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+		} else if text, ok := source["content"].(string); ok {
+			parts = append(parts, text)
+		}
+		return Document{Name: name, ContentType: "text/plain", Data: []byte(strings.Join(parts, "\n\n"))}, nil
+	default:
+		return Document{}, fmt.Errorf("unsupported document source type %v", source["type"])
+	}
+}
+
+// withDefaultName 为没有文件名的文件按顺序生成文件名
+func (d Document) withDefaultName(index int) Document {
+	if d.Name != "" {
+		return d
+	}
+	name := core.DefaultFileName(d.ContentType)
+	if name == "file" {
+		if exts, _ := mime.ExtensionsByType(d.ContentType); len(exts) > 0 {
+			name = "document" + exts[0]
+		} else if d.IsText() {
+			name = "document.txt"
+		}
+	}
+	ext := filepath.Ext(name)
+	d.Name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), index+1, ext)
+	return d
+}
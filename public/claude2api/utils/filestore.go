@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	filesFile = "files.json"
+	// 文件内容保存在数据目录下的该子目录中，文件名为文件 ID
+	filesDir = "files"
+)
+
+// ErrFileNotFound 文件不存在或不属于当前 API Key
+var ErrFileNotFound = errors.New("file not found")
+
+// StoredFile 通过 /v1/files 上传并保存在本地的文件
+type StoredFile struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Purpose     string    `json:"purpose"`
+	Bytes       int       `json:"bytes"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// FileStore 本地文件存储，文件信息保存在 files.json，内容按文件单独保存
+type FileStore struct {
+	mu     sync.Mutex
+	loaded bool
+	files  map[string]*StoredFile
+}
+
+// Files 全局文件存储
+var Files = &FileStore{files: map[string]*StoredFile{}}
+
+// load 首次使用时从数据目录读取文件列表，调用方需持有 s.mu
+func (s *FileStore) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	if err := config.LoadDataFile(filesFile, &s.files); err != nil {
+		logger.Error(fmt.Sprintf("Failed to load files: %v", err))
+	}
+	if s.files == nil {
+		s.files = map[string]*StoredFile{}
+	}
+}
+
+// save 持久化文件列表，调用方需持有 s.mu
+func (s *FileStore) save() {
+	if err := config.SaveDataFile(filesFile, s.files); err != nil {
+		logger.Error(fmt.Sprintf("Failed to save files: %v", err))
+	}
+}
+
+func filePath(id string) string {
+	return filepath.Join(config.DataDir(), filesDir, id)
+}
+
+// Create 保存文件内容并返回文件信息
+func (s *FileStore) Create(owner, filename, contentType, purpose string, data []byte) (StoredFile, error) {
+	file := StoredFile{
+		ID:          "file-" + uuid.New().String(),
+		Owner:       owner,
+		Filename:    filename,
+		ContentType: contentType,
+		Purpose:     purpose,
+		Bytes:       len(data),
+		CreatedAt:   time.Now(),
+	}
+	if err := os.MkdirAll(filepath.Join(config.DataDir(), filesDir), 0700); err != nil {
+		return StoredFile{}, fmt.Errorf("failed to create files directory: %v", err)
+	}
+	if err := os.WriteFile(filePath(file.ID), data, 0600); err != nil {
+		return StoredFile{}, fmt.Errorf("failed to write file: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	s.files[file.ID] = &file
+	s.save()
+	return file, nil
+}
+
+// List 按上传时间倒序返回属于 owner 的文件，purpose 不为空时只返回该用途的文件
+func (s *FileStore) List(owner, purpose string) []StoredFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	files := []StoredFile{}
+	for _, file := range s.files {
+		if file.Owner == owner && (purpose == "" || file.Purpose == purpose) {
+			files = append(files, *file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files
+}
+
+// Get 返回属于 owner 的文件信息
+func (s *FileStore) Get(id, owner string) (StoredFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	file, ok := s.files[id]
+	if !ok || file.Owner != owner {
+		return StoredFile{}, false
+	}
+	return *file, true
+}
+
+// Open 返回属于 owner 的文件信息和内容
+func (s *FileStore) Open(id, owner string) (StoredFile, []byte, error) {
+	file, ok := s.Get(id, owner)
+	if !ok {
+		return StoredFile{}, nil, ErrFileNotFound
+	}
+	data, err := os.ReadFile(filePath(id))
+	if err != nil {
+		return StoredFile{}, nil, fmt.Errorf("failed to read file %s: %v", id, err)
+	}
+	return file, data, nil
+}
+
+// Delete 删除属于 owner 的文件及其内容
+func (s *FileStore) Delete(id, owner string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	file, ok := s.files[id]
+	if !ok || file.Owner != owner {
+		return false
+	}
+	delete(s.files, id)
+	s.save()
+	if err := os.Remove(filePath(id)); err != nil && !os.IsNotExist(err) {
+		logger.Error(fmt.Sprintf("Failed to remove file %s: %v", id, err))
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 允许下载的图片类型
+var allowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// 最多跟随的重定向次数
+const maxImageRedirects = 3
+
+// 不在 net.IP 的 IsPrivate 等判断范围内、但同样不应访问的地址段
+var reservedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// ImageError 单张图片处理失败的原因
+type ImageError struct {
+	Index int
+	URL   string
+	Err   error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("image %d (%s): %v", e.Index+1, e.URL, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// isPublicIP 判断地址是否为公网地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageTransport 直连下载图片，在建立连接时检查实际解析到的地址，防止通过 DNS 指向内网
+var imageTransport = &http.Transport{
+	Proxy: nil,
+	DialContext: (&net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if config.ConfigInstance.ImageFetchAllowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		},
+	}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+	MaxIdleConns:        10,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// fetchImage 下载远程图片，返回内容类型和数据
+func fetchImage(ctx context.Context, rawURL string) (string, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", nil, errors.New("only http and https URLs are supported")
+	}
+
+	maxBytes := int64(config.ConfigInstance.ImageFetchMaxMB) << 20
+	client := &http.Client{
+		Transport: imageTransport,
+		Timeout:   time.Duration(config.ConfigInstance.ImageFetchTimeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxImageRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to unsupported scheme")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("download failed: status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return "", nil, fmt.Errorf("image is larger than %d MB", config.ConfigInstance.ImageFetchMaxMB)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("download failed: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return "", nil, fmt.Errorf("image is larger than %d MB", config.ConfigInstance.ImageFetchMaxMB)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !allowedImageTypes[contentType] {
+		return "", nil, fmt.Errorf("content type %q is not an allowed image type", contentType)
+	}
+	return contentType, data, nil
+}
+
+// 同时下载和处理的图片数量上限
+const maxConcurrentImages = 4
+
+var imageSlots = make(chan struct{}, maxConcurrentImages)
+
+// PrepareImages 并发处理消息中的图片：下载以 http(s) 地址给出的图片，并经过 PreprocessImage
+// 校验、缩小和重新编码（noImagePreprocess 时跳过），结果替换为 data URI。
+// 返回的错误包含每张失败图片的 *ImageError
+func PrepareImages(ctx context.Context, messages []map[string]interface{}) error {
+	type imageRef struct {
+		index    int
+		imageUrl map[string]interface{}
+	}
+	var images []imageRef
+	count := 0
+	for _, msg := range messages {
+		items, ok := msg["content"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok || itemMap["type"] != "image_url" {
+				continue
+			}
+			imageUrl, ok := itemMap["image_url"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := imageUrl["url"].(string); ok {
+				images = append(images, imageRef{index: count, imageUrl: imageUrl})
+			}
+			count++
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	if len(images) > config.ConfigInstance.MaxImages {
+		return fmt.Errorf("request has %d images, at most %d are allowed", len(images), config.ConfigInstance.MaxImages)
+	}
+
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, image imageRef) {
+			defer wg.Done()
+			// 所有请求共用，限制同时在内存中解码的图片数量
+			rawURL := image.imageUrl["url"].(string)
+			select {
+			case imageSlots <- struct{}{}:
+				defer func() { <-imageSlots }()
+			case <-ctx.Done():
+				errs[i] = &ImageError{Index: image.index, URL: shortenImageURL(rawURL), Err: ctx.Err()}
+				return
+			}
+			dataURI, err := prepareImage(ctx, rawURL)
+			if err != nil {
+				errs[i] = &ImageError{Index: image.index, URL: shortenImageURL(rawURL), Err: err}
+				return
+			}
+			image.imageUrl["url"] = dataURI
+		}(i, image)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// prepareImage 下载或解码单张图片并预处理，返回 data URI
+func prepareImage(ctx context.Context, rawURL string) (string, error) {
+	var contentType string
+	var data []byte
+	var err error
+	if strings.HasPrefix(rawURL, "data:") {
+		if config.ConfigInstance.NoImagePreprocess {
+			return rawURL, nil
+		}
+		contentType, data, err = DecodeDataURI(rawURL)
+	} else {
+		contentType, data, err = fetchImage(ctx, rawURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !config.ConfigInstance.NoImagePreprocess {
+		original := len(data)
+		contentType, data, err = PreprocessImage(data)
+		if err != nil {
+			return "", err
+		}
+		logger.Debug(fmt.Sprintf("Preprocessed image: %d -> %d bytes, %s", original, len(data), contentType))
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// shortenImageURL 错误信息中不输出完整的 data URI
+func shortenImageURL(rawURL string) string {
+	if meta, _, ok := strings.Cut(rawURL, ","); ok && strings.HasPrefix(meta, "data:") {
+		return meta + ",..."
+	}
+	return rawURL
+}
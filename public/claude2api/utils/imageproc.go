@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/config"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// PNG 编码后超过该大小且不透明时，改用 JPEG
+const pngRecompressBytes = 2 << 20
+
+// DetectImageFormat 根据文件头识别图片格式，无法识别时返回空字符串
+func DetectImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(data, []byte("BM")):
+		return "bmp"
+	}
+	return ""
+}
+
+// DecodeDataURI 解析 base64 编码的 data URI，返回内容类型和数据
+func DecodeDataURI(dataURI string) (string, []byte, error) {
+	meta, encoded, ok := strings.Cut(dataURI, ",")
+	if !ok || !strings.HasPrefix(meta, "data:") {
+		return "", nil, errors.New("invalid data URI")
+	}
+	contentType, encoding, _ := strings.Cut(strings.TrimPrefix(meta, "data:"), ";")
+	if encoding != "base64" {
+		return "", nil, errors.New("data URI is not base64 encoded")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode base64 data: %w", err)
+	}
+	return contentType, data, nil
+}
+
+// PreprocessImage 校验图片格式，按配置缩小尺寸并重新编码为 PNG 或 JPEG，同时去掉元数据。
+// JPEG 的 EXIF 方向会在去掉元数据前应用到缩小后的像素上
+func PreprocessImage(data []byte) (string, []byte, error) {
+	format := DetectImageFormat(data)
+	if format == "" {
+		return "", nil, errors.New("unsupported image format")
+	}
+	cfg, _, err := decodeImageConfig(format, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid %s image: %w", format, err)
+	}
+	// 解码前拒绝像素过多的图片，防止解压炸弹
+	if cfg.Width*cfg.Height > config.ConfigInstance.ImageMaxPixels {
+		return "", nil, fmt.Errorf("image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, err := decodeImage(format, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid %s image: %w", format, err)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	// 先缩小再旋转，旋转 90 度的方向（5-8）缩小时交换最大宽高
+	maxWidth, maxHeight := config.ConfigInstance.ImageMaxWidth, config.ConfigInstance.ImageMaxHeight
+	if orientation >= 5 && orientation <= 8 {
+		maxWidth, maxHeight = maxHeight, maxWidth
+	}
+	img = applyOrientation(fitImage(img, maxWidth, maxHeight), orientation)
+
+	var buf bytes.Buffer
+	quality := &jpeg.Options{Quality: config.ConfigInstance.ImageJPEGQuality}
+	if format == "jpeg" {
+		if err := jpeg.Encode(&buf, img, quality); err != nil {
+			return "", nil, err
+		}
+		return "image/jpeg", buf.Bytes(), nil
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return "", nil, err
+	}
+	// 不透明的大图（多为照片）用 JPEG 更小
+	if buf.Len() > pngRecompressBytes && isOpaque(img) {
+		var jpegBuf bytes.Buffer
+		if err := jpeg.Encode(&jpegBuf, img, quality); err == nil && jpegBuf.Len() < buf.Len() {
+			return "image/jpeg", jpegBuf.Bytes(), nil
+		}
+	}
+	return "image/png", buf.Bytes(), nil
+}
+
+func decodeImageConfig(format string, data []byte) (image.Config, string, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case "webp":
+		cfg, err := webp.DecodeConfig(r)
+		return cfg, format, err
+	case "bmp":
+		cfg, err := bmp.DecodeConfig(r)
+		return cfg, format, err
+	}
+	return image.DecodeConfig(r)
+}
+
+// decodeImage 解码图片，GIF 只取第一帧
+func decodeImage(format string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case "jpeg":
+		return jpeg.Decode(r)
+	case "png":
+		return png.Decode(r)
+	case "gif":
+		return gif.Decode(r)
+	case "webp":
+		return webp.Decode(r)
+	case "bmp":
+		return bmp.Decode(r)
+	}
+	return nil, errors.New("unsupported image format")
+}
+
+// fitImage 等比缩小图片使其不超过最大宽高
+func fitImage(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+	scale := float64(maxWidth) / float64(w)
+	if s := float64(maxHeight) / float64(h); s < scale {
+		scale = s
+	}
+	nw, nh := int(float64(w)*scale), int(float64(h)*scale)
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// jpegOrientation 读取 JPEG 中 EXIF 的方向标记，没有时返回 1
+func jpegOrientation(data []byte) int {
+	// 跳过 SOI，依次查找 APP1 段
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation 在 TIFF 结构的 IFD0 中查找方向标记（0x0112）
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8 : entry+10])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation 按 EXIF 方向旋转或翻转图片
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// 5-8 需要交换宽高
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// 先转换为 RGBA，再按字节复制像素
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Bounds().Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
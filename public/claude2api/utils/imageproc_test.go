@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// buildTIFF 生成只含 IFD0 的 TIFF 结构，entries 为 (标记, SHORT 值) 对
+func buildTIFF(order binary.ByteOrder, entries [][2]uint16) []byte {
+	tiff := make([]byte, 10, 10+12*len(entries))
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:4], 42)
+	order.PutUint32(tiff[4:8], 8)
+	order.PutUint16(tiff[8:10], uint16(len(entries)))
+	for _, e := range entries {
+		entry := make([]byte, 12)
+		order.PutUint16(entry[0:2], e[0])
+		order.PutUint16(entry[2:4], 3)
+		order.PutUint32(entry[4:8], 1)
+		order.PutUint16(entry[8:10], e[1])
+		tiff = append(tiff, entry...)
+	}
+	return tiff
+}
+
+// buildJPEG 生成依次包含各段和 SOS 的 JPEG 开头
+func buildJPEG(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, segment := range segments {
+		data = append(data, segment...)
+	}
+	return append(data, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func segment(marker byte, payload []byte) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:4], uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", buildTIFF(binary.LittleEndian, [][2]uint16{{0x0112, 6}}), 6},
+		{"big endian", buildTIFF(binary.BigEndian, [][2]uint16{{0x0112, 8}}), 8},
+		{"after other tags", buildTIFF(binary.BigEndian, [][2]uint16{{0x010F, 1}, {0x0112, 3}}), 3},
+		{"no orientation tag", buildTIFF(binary.LittleEndian, [][2]uint16{{0x010F, 1}}), 1},
+		{"out of range value", buildTIFF(binary.LittleEndian, [][2]uint16{{0x0112, 9}}), 1},
+		{"unknown byte order", append([]byte("XX"), make([]byte, 12)...), 1},
+		{"too short", []byte("II*"), 1},
+		{"truncated entries", buildTIFF(binary.LittleEndian, [][2]uint16{{0x0112, 6}})[:14], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	rotated := buildTIFF(binary.BigEndian, [][2]uint16{{0x0112, 6}})
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"exif segment", buildJPEG(exifSegment(rotated)), 6},
+		{"exif after jfif segment", buildJPEG(segment(0xE0, []byte("JFIF\x00\x01\x01")), exifSegment(rotated)), 6},
+		{"xmp app1 is skipped", buildJPEG(segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00")), exifSegment(rotated)), 6},
+		{"no exif", buildJPEG(segment(0xE0, []byte("JFIF\x00"))), 1},
+		{"exif after scan is ignored", append(buildJPEG(), exifSegment(rotated)...), 1},
+		{"segment longer than data", buildJPEG(exifSegment(rotated))[:10], 1},
+		{"not a jpeg segment", []byte{0xFF, 0xD8, 0x00}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1 的图片：左红右蓝
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		// want 为方向校正后按行排列的像素
+		want [][]color.RGBA
+	}{
+		{1, [][]color.RGBA{{red, blue}}},
+		{2, [][]color.RGBA{{blue, red}}},
+		{3, [][]color.RGBA{{blue, red}}},
+		{6, [][]color.RGBA{{red}, {blue}}},
+		{8, [][]color.RGBA{{blue}, {red}}},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		b := got.Bounds()
+		if b.Dx() != len(tt.want[0]) || b.Dy() != len(tt.want) {
+			t.Errorf("orientation %d: got size %dx%d", tt.orientation, b.Dx(), b.Dy())
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if c := color.RGBAModel.Convert(got.At(b.Min.X+x, b.Min.Y+y)); c != want {
+					t.Errorf("orientation %d: pixel (%d,%d) = %v, want %v", tt.orientation, x, y, c, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// JSONOutput 请求要求的 JSON 输出，对应 response_format 的 json_object 和 json_schema
+type JSONOutput struct {
+	// Name json_schema 的名称
+	Name   string
+	Schema map[string]interface{}
+	// prefill 回复的预填内容，校验时与回复拼接
+	prefill string
+}
+
+// 匹配回复中的代码块
+var codeFencePattern = regexp.MustCompile("(?s)```[a-zA-Z0-9_-]*[ \t]*\n(.*?)```")
+
+// ParseResponseFormat 解析 response_format，为空或 text 时返回 nil
+func ParseResponseFormat(format *model.ResponseFormat) (*JSONOutput, error) {
+	if format == nil {
+		return nil, nil
+	}
+	switch format.Type {
+	case "", "text":
+		return nil, nil
+	case "json_object":
+		return &JSONOutput{}, nil
+	case "json_schema":
+		if format.JSONSchema == nil || format.JSONSchema.Schema == nil {
+			return nil, errors.New("json_schema.schema is required")
+		}
+		return &JSONOutput{Name: format.JSONSchema.Name, Schema: format.JSONSchema.Schema}, nil
+	}
+	return nil, fmt.Errorf("unsupported response_format type %q", format.Type)
+}
+
+// Instruction 加在提示词末尾的输出要求
+func (o *JSONOutput) Instruction() string {
+	if o.Schema == nil {
+		return "Reply with a single valid JSON object and nothing else: no code fences, no explanations."
+	}
+	schema, _ := json.MarshalIndent(o.Schema, "", "  ")
+	name := ""
+	if o.Name != "" {
+		name = " " + o.Name
+	}
+	return fmt.Sprintf("Reply with a single JSON value that conforms to the following JSON schema%s, and nothing else: no code fences, no explanations.\n%s", name, schema)
+}
+
+// Correction 回复不符合要求时，要求重新回复的消息
+func (o *JSONOutput) Correction(err error) string {
+	return fmt.Sprintf("Your previous reply was rejected: %v. Reply again with only the corrected JSON, no code fences and no explanations.", err)
+}
+
+// Extract 从回复中取出 JSON（可以被代码块包裹，或前后带有说明文字）并校验，返回 JSON 文本
+func (o *JSONOutput) Extract(reply string) (string, error) {
+	if o.prefill != "" {
+		// 回复多半从预填内容之后继续，也可能完整地重新回复
+		if text, err := o.extract(o.prefill + StripPrefill(o.prefill, reply)); err == nil {
+			return text, nil
+		}
+	}
+	return o.extract(reply)
+}
+
+func (o *JSONOutput) extract(reply string) (string, error) {
+	raw, err := findJSON(reply)
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	if o.Schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "", errors.New("reply is not a JSON object")
+		}
+	} else if err := ValidateJSONSchema(o.Schema, value); err != nil {
+		return "", fmt.Errorf("reply does not match the schema: %w", err)
+	}
+	return string(raw), nil
+}
+
+// findJSON 依次尝试整个回复、代码块中的内容以及从第一个 { 或 [ 开始的 JSON
+func findJSON(reply string) ([]byte, error) {
+	text := strings.TrimSpace(reply)
+	if json.Valid([]byte(text)) {
+		return []byte(text), nil
+	}
+	for _, match := range codeFencePattern.FindAllStringSubmatch(text, -1) {
+		if block := strings.TrimSpace(match[1]); json.Valid([]byte(block)) {
+			return []byte(block), nil
+		}
+	}
+	if start := strings.IndexAny(text, "{["); start >= 0 {
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&raw); err == nil {
+			return bytes.TrimSpace(raw), nil
+		}
+	}
+	if text == "" {
+		return nil, errors.New("reply is empty")
+	}
+	return nil, errors.New("reply does not contain valid JSON")
+}
+
+// SetOutput 要求回复为 JSON，并在提示词中加入相应的说明
+func (p *ChatRequestProcessor) SetOutput(output *JSONOutput) {
+	p.Output = output
+	if output != nil {
+		output.prefill = p.Prefill
+		p.AddInstruction(output.Instruction())
+	}
+}
+
+// AddInstruction 在提示词末尾加入一条系统说明，上下文转为附件或截断时保留在提示词中
+func (p *ChatRequestProcessor) AddInstruction(text string) {
+	instruction := p.format().Message(PromptMessage{Role: "system", Content: text, Index: len(p.turns), Last: true})
+	p.instructions += instruction
+	p.Prompt.WriteString(instruction)
+	p.RootPrompt.WriteString(instruction)
+}
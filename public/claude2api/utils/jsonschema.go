@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema 按 JSON Schema 校验 json.Unmarshal 得到的值，返回第一处不符合的位置和原因。
+// 支持 type、enum、const、properties、required、additionalProperties、items、prefixItems、
+// 长度和数值范围、pattern、allOf、anyOf、oneOf、not 以及指向 $defs / definitions 的 $ref，其他关键字忽略
+func ValidateJSONSchema(schema map[string]interface{}, value interface{}) error {
+	v := &schemaValidator{root: schema}
+	return v.validate(schema, value, "$", 0)
+}
+
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+// 防止 $ref 循环引用
+const maxSchemaDepth = 64
+
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema is nested too deeply", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := v.validate(target, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, describeType(t), jsonType(value))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %s", path, compactJSON(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fmt.Errorf("%s: value must be %s", path, compactJSON(c))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if err := v.validateObject(schema, val, path, depth); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := v.validateArray(schema, val, path, depth); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(val)
+		if min, ok := schemaNumber(schema, "minLength"); ok && float64(length) < min {
+			return fmt.Errorf("%s: string is shorter than %v characters", path, min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && float64(length) > max {
+			return fmt.Errorf("%s: string is longer than %v characters", path, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err == nil && !re.MatchString(val) {
+				return fmt.Errorf("%s: string does not match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema, "minimum"); ok && val < min {
+			return fmt.Errorf("%s: value is less than %v", path, min)
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok && val > max {
+			return fmt.Errorf("%s: value is greater than %v", path, max)
+		}
+		if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && val <= min {
+			return fmt.Errorf("%s: value must be greater than %v", path, min)
+		}
+		if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && val >= max {
+			return fmt.Errorf("%s: value must be less than %v", path, max)
+		}
+		if m, ok := schemaNumber(schema, "multipleOf"); ok && m > 0 {
+			if q := val / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				return fmt.Errorf("%s: value is not a multiple of %v", path, m)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := v.validate(asSchema(sub), value, path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var first error
+		matched := false
+		for _, sub := range anyOf {
+			err := v.validate(asSchema(sub), value, path, depth+1)
+			if err == nil {
+				matched = true
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if !matched && first != nil {
+			return fmt.Errorf("%s: value matches none of anyOf (%v)", path, first)
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if v.validate(asSchema(sub), value, path, depth+1) == nil {
+				count++
+			}
+		}
+		if count != 1 {
+			return fmt.Errorf("%s: value matches %d of oneOf, expected exactly 1", path, count)
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok {
+		if v.validate(not, value, path, depth+1) == nil {
+			return fmt.Errorf("%s: value must not match the schema in not", path)
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, depth int) error {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := obj[key]; !exists {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+	// 按名称顺序校验，使错误信息稳定
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		childPath := path + "." + key
+		if prop, ok := properties[key]; ok {
+			if err := v.validate(asSchema(prop), obj[key], childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: unexpected property %q", path, key)
+			}
+		case map[string]interface{}:
+			if err := v.validate(additional, obj[key], childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if min, ok := schemaNumber(schema, "minProperties"); ok && float64(len(obj)) < min {
+		return fmt.Errorf("%s: object has fewer than %v properties", path, min)
+	}
+	if max, ok := schemaNumber(schema, "maxProperties"); ok && float64(len(obj)) > max {
+		return fmt.Errorf("%s: object has more than %v properties", path, max)
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, arr []interface{}, path string, depth int) error {
+	if min, ok := schemaNumber(schema, "minItems"); ok && float64(len(arr)) < min {
+		return fmt.Errorf("%s: array has fewer than %v items", path, min)
+	}
+	if max, ok := schemaNumber(schema, "maxItems"); ok && float64(len(arr)) > max {
+		return fmt.Errorf("%s: array has more than %v items", path, max)
+	}
+	prefix, _ := schema["prefixItems"].([]interface{})
+	for i, item := range arr {
+		childPath := fmt.Sprintf("%s[%d]", path, i)
+		if i < len(prefix) {
+			if err := v.validate(asSchema(prefix[i]), item, childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch items := schema["items"].(type) {
+		case map[string]interface{}:
+			if err := v.validate(items, item, childPath, depth+1); err != nil {
+				return err
+			}
+		case bool:
+			if !items {
+				return fmt.Errorf("%s: unexpected item", childPath)
+			}
+		}
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					return fmt.Errorf("%s: items %d and %d are equal", path, i, j)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolve 解析文档内的 $ref，例如 #/$defs/address
+func (v *schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if node, ok = obj[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return schema, nil
+}
+
+// asSchema 把子模式转为 map，true 或无法识别的值视为不做限制
+func asSchema(value interface{}) map[string]interface{} {
+	switch s := value.(type) {
+	case map[string]interface{}:
+		return s
+	case bool:
+		if !s {
+			return map[string]interface{}{"not": map[string]interface{}{}}
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []interface{}:
+		for _, name := range t {
+			if s, ok := name.(string); ok && matchesTypeName(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value interface{}) bool {
+	actual := jsonType(value)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+	return name == actual
+}
+
+// jsonType 返回值的 JSON 类型名，整数值为 integer
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func describeType(t interface{}) string {
+	if names, ok := t.([]interface{}); ok {
+		parts := make([]string, 0, len(names))
+		for _, name := range names {
+			parts = append(parts, fmt.Sprint(name))
+		}
+		return strings.Join(parts, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	const person = `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"address": {"$ref": "#/$defs/address"}
+		},
+		"required": ["name"],
+		"additionalProperties": false,
+		"$defs": {
+			"address": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+		}
+	}`
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		// wantErr 为空表示校验通过，否则为错误信息中应包含的内容
+		wantErr string
+	}{
+		{"valid object", person, `{"name": "Ann", "age": 30, "address": {"city": "Paris"}}`, ""},
+		{"missing required", person, `{"age": 30}`, `$: missing required property "name"`},
+		{"wrong type", person, `{"name": 5}`, "$.name: expected string, got integer"},
+		{"integer expected", person, `{"name": "Ann", "age": 1.5}`, "$.age: expected integer, got number"},
+		{"below minimum", person, `{"name": "Ann", "age": -1}`, "$.age: value is less than 0"},
+		{"additional property", person, `{"name": "Ann", "x": 1}`, `unexpected property "x"`},
+		{"ref target", person, `{"name": "Ann", "address": {}}`, `$.address: missing required property "city"`},
+		{"empty string", person, `{"name": ""}`, "shorter than 1 characters"},
+		{"number accepts integer", `{"type": "number"}`, `3`, ""},
+		{"type list", `{"type": ["string", "null"]}`, `null`, ""},
+		{"enum", `{"enum": ["a", "b"]}`, `"c"`, `value is not one of ["a","b"]`},
+		{"const", `{"const": 1}`, `1`, ""},
+		{"pattern", `{"type": "string", "pattern": "^[a-z]+$"}`, `"ABC"`, "does not match pattern"},
+		{"array items", `{"type": "array", "items": {"type": "integer"}}`, `[1, "x"]`, "$[1]: expected integer, got string"},
+		{"prefix items", `{"prefixItems": [{"type": "string"}], "items": false}`, `["a", 1]`, "$[1]: unexpected item"},
+		{"min items", `{"type": "array", "minItems": 2}`, `[1]`, "fewer than 2 items"},
+		{"unique items", `{"uniqueItems": true}`, `[1, 2, 1]`, "items 0 and 2 are equal"},
+		{"any of", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, "matches none of anyOf"},
+		{"one of matches two", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, "matches 2 of oneOf"},
+		{"not", `{"not": {"type": "null"}}`, `null`, "must not match"},
+		{"multiple of", `{"multipleOf": 0.5}`, `1.5`, ""},
+		{"unsupported ref", `{"$ref": "http://example.com/schema"}`, `1`, "unsupported $ref"},
+		{"recursive ref", `{"$ref": "#"}`, `1`, "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatalf("invalid schema: %v", err)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("invalid value: %v", err)
+			}
+			err := ValidateJSONSchema(schema, value)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("expected error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("error %q does not contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"strings"
+)
+
+// OutputFilter 在服务端实现 stop 和 max_tokens：回复在第一个停止序列处截断，
+// 或在估算的 token 数达到上限时截断。有预填内容时去掉回复开头重复的预填内容。实现 core.OutputFilter
+type OutputFilter struct {
+	// prefill 尚未确定回复是否重复了预填内容时不为空，head 为期间暂存的回复
+	prefill   string
+	head      string
+	stops     []string
+	maxTokens int
+	// 已发送文本的字数，按 EstimateTokens 的方式累计
+	cjk, other int
+	// pending 可能是停止序列开头而暂不发送的文本
+	pending string
+	reason  string
+}
+
+// NewOutputFilter 创建过滤器，没有预填内容、没有停止序列且不限制 token 数时返回 nil
+func NewOutputFilter(prefill string, stops []string, maxTokens int) *OutputFilter {
+	prefill = strings.TrimSpace(prefill)
+	var valid []string
+	for _, s := range stops {
+		if s != "" {
+			valid = append(valid, s)
+		}
+	}
+	if prefill == "" && len(valid) == 0 && maxTokens <= 0 {
+		return nil
+	}
+	return &OutputFilter{prefill: prefill, stops: valid, maxTokens: maxTokens}
+}
+
+// Write 返回可以发送的文本，stop 表示回复到此结束
+func (f *OutputFilter) Write(delta string) (string, bool) {
+	if f.reason != "" {
+		return "", true
+	}
+	if f.prefill != "" {
+		f.head += delta
+		trimmed := strings.TrimLeft(f.head, " \t\r\n")
+		if len(trimmed) < len(f.prefill) && strings.HasPrefix(f.prefill, trimmed) {
+			return "", false
+		}
+		delta = StripPrefill(f.prefill, f.head)
+		f.prefill, f.head = "", ""
+	}
+	text := f.pending + delta
+	f.pending = ""
+	if cut := f.firstStop(text); cut >= 0 {
+		text = text[:cut]
+		f.reason = "stop"
+	} else {
+		// 保留末尾可能被下一块补全的停止序列开头
+		hold := f.holdBack(text)
+		f.pending = text[len(text)-hold:]
+		text = text[:len(text)-hold]
+	}
+	text = f.limit(text)
+	return text, f.reason != ""
+}
+
+// Flush 回复正常结束时返回暂存的文本
+func (f *OutputFilter) Flush() string {
+	if f.reason != "" {
+		return ""
+	}
+	// 回复比预填内容短时原样返回，同样在停止序列处截断
+	text := f.head + f.pending
+	f.head = ""
+	f.pending = ""
+	if cut := f.firstStop(text); cut >= 0 {
+		text = text[:cut]
+		f.reason = "stop"
+	}
+	return f.limit(text)
+}
+
+// FinishReason 截断于 max_tokens 时为 length，否则为 stop
+func (f *OutputFilter) FinishReason() string {
+	if f.reason == "" {
+		return "stop"
+	}
+	return f.reason
+}
+
+// firstStop 返回最早出现的停止序列的位置，没有时返回 -1
+func (f *OutputFilter) firstStop(text string) int {
+	cut := -1
+	for _, s := range f.stops {
+		if i := strings.Index(text, s); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	return cut
+}
+
+// holdBack 返回 text 末尾与某个停止序列开头相同的最长部分的字节数
+func (f *OutputFilter) holdBack(text string) int {
+	hold := 0
+	for _, s := range f.stops {
+		for n := len(s) - 1; n > hold; n-- {
+			if strings.HasSuffix(text, s[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	return hold
+}
+
+// limit 在累计的估算 token 数超过 maxTokens 处截断
+func (f *OutputFilter) limit(text string) string {
+	if f.maxTokens <= 0 {
+		return text
+	}
+	for i, r := range text {
+		cjk, other := f.cjk, f.other
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+		if cjk+(other+3)/4 > f.maxTokens {
+			f.reason = "length"
+			f.pending = ""
+			return text[:i]
+		}
+		f.cjk, f.other = cjk, other
+	}
+	return text
+}
+
+// StripPrefill 去掉回复开头重复的预填内容，回复的开头空白不影响比较
+func StripPrefill(prefill, reply string) string {
+	prefill = strings.TrimSpace(prefill)
+	trimmed := strings.TrimLeft(reply, " \t\r\n")
+	if prefill != "" && strings.HasPrefix(trimmed, prefill) {
+		return trimmed[len(prefill):]
+	}
+	return reply
+}
+
+// SetOutputLimits 设置请求的 stop 和 max_tokens
+func (p *ChatRequestProcessor) SetOutputLimits(stops []string, maxTokens int) {
+	p.stops = stops
+	p.maxTokens = maxTokens
+}
+
+// OutputFilter 返回本次回复使用的过滤器，除请求的停止序列外还包括提示词格式的角色前缀，
+// 不需要过滤时返回 nil。过滤器有状态，每次发送都要重新创建
+func (p *ChatRequestProcessor) OutputFilter() core.OutputFilter {
+	stops := append([]string(nil), p.stops...)
+	if !config.ConfigInstance.NoRoleLeakGuard {
+		stops = append(stops, p.format().RoleMarkers()...)
+	}
+	if filter := NewOutputFilter(p.Prefill, stops, p.maxTokens); filter != nil {
+		return filter
+	}
+	return nil
+}
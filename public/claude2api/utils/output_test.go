@@ -0,0 +1,181 @@
+package utils
+
+import "testing"
+
+// runFilter 依次写入各块回复，返回客户端收到的全部文本和结束原因
+func runFilter(f *OutputFilter, chunks []string) (string, string) {
+	var out string
+	for _, chunk := range chunks {
+		text, stop := f.Write(chunk)
+		out += text
+		if stop {
+			return out, f.FinishReason()
+		}
+	}
+	return out + f.Flush(), f.FinishReason()
+}
+
+func TestNewOutputFilterNothingToDo(t *testing.T) {
+	if f := NewOutputFilter("  ", []string{""}, 0); f != nil {
+		t.Fatalf("expected nil filter, got %+v", f)
+	}
+}
+
+func TestOutputFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		stops      []string
+		maxTokens  int
+		chunks     []string
+		want       string
+		wantReason string
+	}{
+		{
+			name:       "no stop in reply",
+			stops:      []string{"END"},
+			chunks:     []string{"hello ", "world"},
+			want:       "hello world",
+			wantReason: "stop",
+		},
+		{
+			name:       "stop inside one chunk",
+			stops:      []string{"END"},
+			chunks:     []string{"helloEND world"},
+			want:       "hello",
+			wantReason: "stop",
+		},
+		{
+			name:       "stop split across chunks",
+			stops:      []string{"\nHuman:"},
+			chunks:     []string{"answer\nHu", "man: next"},
+			want:       "answer",
+			wantReason: "stop",
+		},
+		{
+			name:       "held back prefix that is not a stop",
+			stops:      []string{"\nHuman:"},
+			chunks:     []string{"line\nHu", "go"},
+			want:       "line\nHugo",
+			wantReason: "stop",
+		},
+		{
+			name:       "earliest of several stops",
+			stops:      []string{"bbb", "a"},
+			chunks:     []string{"xxbbbya"},
+			want:       "xx",
+			wantReason: "stop",
+		},
+		{
+			name:       "max tokens in latin text",
+			maxTokens:  2,
+			chunks:     []string{"hello ", "world"},
+			want:       "hello wo",
+			wantReason: "length",
+		},
+		{
+			name:       "max tokens in cjk text",
+			maxTokens:  3,
+			chunks:     []string{"你好", "世界"},
+			want:       "你好世",
+			wantReason: "length",
+		},
+		{
+			name:       "reply within max tokens",
+			maxTokens:  10,
+			chunks:     []string{"short"},
+			want:       "short",
+			wantReason: "stop",
+		},
+		{
+			name:       "stop before token limit",
+			stops:      []string{"."},
+			maxTokens:  2,
+			chunks:     []string{"ab. cdefghijk"},
+			want:       "ab",
+			wantReason: "stop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewOutputFilter("", tt.stops, tt.maxTokens)
+			got, reason := runFilter(f, tt.chunks)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("got (%q, %q), want (%q, %q)", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestOutputFilterPrefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefill string
+		stops   []string
+		chunks  []string
+		want    string
+	}{
+		{
+			name:    "reply repeats prefill",
+			prefill: "{\"a\":",
+			chunks:  []string{"{\"a\":", " 1}"},
+			want:    " 1}",
+		},
+		{
+			name:    "repeated prefill split across chunks",
+			prefill: "Sure, here",
+			chunks:  []string{"\nSu", "re, he", "re it is"},
+			want:    " it is",
+		},
+		{
+			name:    "reply continues after prefill",
+			prefill: "Sure, here",
+			chunks:  []string{" it is"},
+			want:    " it is",
+		},
+		{
+			name:    "reply shorter than prefill",
+			prefill: "Sure, here",
+			chunks:  []string{"Sur"},
+			want:    "Sur",
+		},
+		{
+			name:    "stop in reply held back as prefill",
+			prefill: "Done.\nHuman: go on",
+			stops:   []string{"\nHuman:"},
+			chunks:  []string{"Done.\nHuman:"},
+			want:    "Done.",
+		},
+		{
+			name:    "stop after stripped prefill",
+			prefill: "Answer:",
+			stops:   []string{"\nHuman:"},
+			chunks:  []string{"Answer: 42\nHuman: more"},
+			want:    " 42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewOutputFilter(tt.prefill, tt.stops, 0)
+			got, _ := runFilter(f, tt.chunks)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripPrefill(t *testing.T) {
+	tests := []struct {
+		prefill, reply, want string
+	}{
+		{"Hello", "Hello world", " world"},
+		{" Hello ", "\n Hello world", " world"},
+		{"Hello", "Goodbye", "Goodbye"},
+		{"", "  text", "  text"},
+	}
+	for _, tt := range tests {
+		if got := StripPrefill(tt.prefill, tt.reply); got != tt.want {
+			t.Errorf("StripPrefill(%q, %q) = %q, want %q", tt.prefill, tt.reply, got, tt.want)
+		}
+	}
+}
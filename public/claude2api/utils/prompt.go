@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// 内置的提示词格式
+const (
+	PromptFormatRolePrefix = "role_prefix"
+	PromptFormatPlain      = "plain"
+	PromptFormatXML        = "xml"
+)
+
+const (
+	disableArtifactsTemplate = "System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n"
+	bigContextTemplate       = `You must immerse yourself in the role of assistant in {{join .Files ", "}}, cannot respond as a user, cannot reply to this message, cannot mention this message, and ignore this message in your response.` + "\n\n"
+	bigContextLatestTemplate = `The earlier part of this conversation is in the attached files {{join .Files ", "}}, in order. Continue the conversation as the assistant and reply to the latest messages below. Do not mention the attached files or this note.` + "\n\n"
+)
+
+var builtinPromptFormats = map[string]config.PromptFormat{
+	// 每条消息前加 Human: 等角色前缀（noRolePrefix 时不加）
+	PromptFormatRolePrefix: {
+		Message:          "{{rolePrefix .Role}}{{.Content}}\n\n",
+		Artifacts:        disableArtifactsTemplate,
+		BigContext:       bigContextTemplate,
+		BigContextLatest: bigContextLatestTemplate,
+		Stop:             []string{"\nHuman:", "\nSystem:"},
+	},
+	// 只有消息内容
+	PromptFormatPlain: {
+		Message: "{{.Content}}\n\n",
+	},
+	// 每条消息用以角色命名的 XML 标签包裹
+	PromptFormatXML: {
+		Message: "<{{.Role}}>\n{{.Content}}\n</{{.Role}}>\n\n",
+		BigContextLatest: `The earlier part of this conversation is in the attached files {{join .Files ", "}}, in order, with every message wrapped in a tag named after its role. ` +
+			`Continue the conversation as the assistant and reply to the latest messages below. Do not mention the attached files or this note.` + "\n\n",
+		Stop: []string{"\n<user>", "\n<system>"},
+	},
+}
+
+var promptFuncs = template.FuncMap{
+	"rolePrefix": GetRolePrefix,
+	"join":       strings.Join,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+}
+
+// PromptMessage 渲染一条消息时模板的数据
+type PromptMessage struct {
+	Role    string
+	Content string
+	// Index 消息在请求中的序号，从 0 开始
+	Index int
+	// Last 是否为请求中的最后一条消息
+	Last bool
+}
+
+// PromptFormat 编译后的提示词格式，见 config.PromptFormat
+type PromptFormat struct {
+	Name             string
+	message          *template.Template
+	artifacts        *template.Template
+	bigContext       *template.Template
+	bigContextLatest *template.Template
+	// stop 其他角色发言的开头，见 RoleMarkers
+	stop []string
+}
+
+// 已编译的格式，配置在运行期间不会变化
+var promptFormatCache sync.Map
+
+// LoadPromptFormat 返回指定名称的提示词格式，promptFormats 中的定义优先于内置格式
+func LoadPromptFormat(name string) (*PromptFormat, error) {
+	if cached, ok := promptFormatCache.Load(name); ok {
+		return cached.(*PromptFormat), nil
+	}
+	def, ok := config.ConfigInstance.PromptFormats[name]
+	if !ok {
+		def, ok = builtinPromptFormats[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt format %q", name)
+	}
+	// 未设置的模板使用 role_prefix 格式的模板
+	base := builtinPromptFormats[PromptFormatRolePrefix]
+	format := &PromptFormat{Name: name, stop: def.Stop}
+	if def.Message == "" && len(def.Stop) == 0 {
+		// 沿用 role_prefix 的消息模板时也沿用其角色前缀
+		format.stop = base.Stop
+	}
+	for _, t := range []struct {
+		dst        **template.Template
+		name, text string
+		fallback   string
+	}{
+		{&format.message, "message", def.Message, base.Message},
+		{&format.artifacts, "artifacts", def.Artifacts, base.Artifacts},
+		{&format.bigContext, "bigContext", def.BigContext, base.BigContext},
+		{&format.bigContextLatest, "bigContextLatest", def.BigContextLatest, base.BigContextLatest},
+	} {
+		text := t.text
+		if text == "" {
+			text = t.fallback
+		}
+		tmpl, err := template.New(name + "." + t.name).Funcs(promptFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template of prompt format %s: %w", t.name, name, err)
+		}
+		*t.dst = tmpl
+	}
+	cached, _ := promptFormatCache.LoadOrStore(name, format)
+	return cached.(*PromptFormat), nil
+}
+
+// DefaultPromptFormat 未配置格式时使用的格式：role_prefix，noRolePrefix 时为 plain
+func DefaultPromptFormat() *PromptFormat {
+	name := PromptFormatRolePrefix
+	if config.ConfigInstance.NoRolePrefix {
+		name = PromptFormatPlain
+	}
+	format, _ := LoadPromptFormat(name)
+	return format
+}
+
+// execute 渲染模板，出错时记录日志并使用默认格式的同名模板
+func (f *PromptFormat) execute(tmpl *template.Template, fallback func(*PromptFormat) *template.Template, data interface{}) string {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err == nil {
+		return buf.String()
+	}
+	logger.Error(fmt.Sprintf("Failed to render prompt format %s: %v", f.Name, err))
+	base, _ := LoadPromptFormat(PromptFormatRolePrefix)
+	if base == f {
+		return ""
+	}
+	buf.Reset()
+	_ = fallback(base).Execute(&buf, data)
+	return buf.String()
+}
+
+// Message 渲染一条消息
+func (f *PromptFormat) Message(msg PromptMessage) string {
+	return f.execute(f.message, func(b *PromptFormat) *template.Template { return b.message }, msg)
+}
+
+// Artifacts 返回禁用 artifacts 的说明
+func (f *PromptFormat) Artifacts() string {
+	return f.execute(f.artifacts, func(b *PromptFormat) *template.Template { return b.artifacts }, nil)
+}
+
+// RoleMarkers 返回回复中表示模型开始替其他角色发言的文本
+func (f *PromptFormat) RoleMarkers() []string {
+	return f.stop
+}
+
+// BigContext 返回上下文作为附件发送时的说明，latest 表示最近的消息保留在提示词中
+func (f *PromptFormat) BigContext(files []string, latest bool) string {
+	data := struct{ Files []string }{files}
+	if latest {
+		return f.execute(f.bigContextLatest, func(b *PromptFormat) *template.Template { return b.bigContextLatest }, data)
+	}
+	return f.execute(f.bigContext, func(b *PromptFormat) *template.Template { return b.bigContext }, data)
+}
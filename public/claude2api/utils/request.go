@@ -1,82 +1,236 @@
-// utils/chat_utils.go
-package utils
-
-import (
-	"claude2api/config"
-	"claude2api/logger"
-	"fmt"
-	"strings"
-)
-
-// ChatRequestProcessor handles common chat request processing logic
-type ChatRequestProcessor struct {
-	Prompt      strings.Builder
-	RootPrompt  strings.Builder
-	ImgDataList []string
-}
-
-// NewChatRequestProcessor creates a new processor instance
-func NewChatRequestProcessor() *ChatRequestProcessor {
-	return &ChatRequestProcessor{
-		Prompt:      strings.Builder{},
-		RootPrompt:  strings.Builder{},
-		ImgDataList: []string{},
-	}
-}
-
-// ProcessMessages processes the messages array into a prompt and extracts images
-func (p *ChatRequestProcessor) ProcessMessages(messages []map[string]interface{}) {
-	if config.ConfigInstance.PromptDisableArtifacts {
-		p.Prompt.WriteString("System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n")
-	}
-
-	for _, msg := range messages {
-		role, roleOk := msg["role"].(string)
-		if !roleOk {
-			continue // Skip invalid format
-		}
-
-		content, exists := msg["content"]
-		if !exists {
-			continue
-		}
-
-		p.Prompt.WriteString(GetRolePrefix(role))
-
-		switch v := content.(type) {
-		case string: // If content is directly a string
-			p.Prompt.WriteString(v + "\n\n")
-		case []interface{}: // If content is an array of []interface{} type
-			for _, item := range v {
-				if itemMap, ok := item.(map[string]interface{}); ok {
-					if itemType, ok := itemMap["type"].(string); ok {
-						if itemType == "text" {
-							if text, ok := itemMap["text"].(string); ok {
-								p.Prompt.WriteString(text + "\n\n")
-							}
-						} else if itemType == "image_url" {
-							if imageUrl, ok := itemMap["image_url"].(map[string]interface{}); ok {
-								if url, ok := imageUrl["url"].(string); ok {
-									p.ImgDataList = append(p.ImgDataList, url)
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-	p.RootPrompt.WriteString(p.Prompt.String())
-	// Debug output
-	logger.Debug(fmt.Sprintf("Processed prompt: %s", p.Prompt.String()))
-	logger.Debug(fmt.Sprintf("Image data list: %v", p.ImgDataList))
-}
-
-// ResetForBigContext resets the prompt for big context usage
-func (p *ChatRequestProcessor) ResetForBigContext() {
-	p.Prompt.Reset()
-	if config.ConfigInstance.PromptDisableArtifacts {
-		p.Prompt.WriteString("System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n")
-	}
-	p.Prompt.WriteString("You must immerse yourself in the role of assistant in context.txt, cannot respond as a user, cannot reply to this message, cannot mention this message, and ignore this message in your response.\n\n")
-}
+// utils/chat_utils.go
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChatRequestProcessor handles common chat request processing logic
+type ChatRequestProcessor struct {
+	Prompt      strings.Builder
+	RootPrompt  strings.Builder
+	ImgDataList []string
+	// Documents 文件和文档内容，按出现顺序
+	Documents []Document
+	// docErrors 无法解析的文件，见 DocumentError
+	docErrors []error
+	// FileOwner 解析 file_id 时文件所属的 API Key，见 FileStore
+	FileOwner string
+	// Format 提示词格式，为空时使用 DefaultPromptFormat
+	Format *PromptFormat
+	// turns 每条消息在提示词中的文本，见 BuildContext
+	turns []promptTurn
+	// instructions 消息之后的系统说明，见 AddInstruction
+	instructions string
+	// Output 要求的 JSON 输出，为空时原样返回回复，见 SetOutput
+	Output *JSONOutput
+	// Prefill 最后一条助手消息的内容，回复从这里继续，见 ProcessMessages
+	Prefill string
+	// stops 和 maxTokens 请求的 stop 和 max_tokens，见 OutputFilter
+	stops     []string
+	maxTokens int
+}
+
+// NewChatRequestProcessor creates a new processor instance
+func NewChatRequestProcessor() *ChatRequestProcessor {
+	return &ChatRequestProcessor{
+		Prompt:      strings.Builder{},
+		RootPrompt:  strings.Builder{},
+		ImgDataList: []string{},
+	}
+}
+
+// Clone 复制处理结果，用于同时发送多次同一请求。图片、文件和消息在副本之间共享，不应修改
+func (p *ChatRequestProcessor) Clone() *ChatRequestProcessor {
+	clone := &ChatRequestProcessor{
+		ImgDataList:  p.ImgDataList,
+		Documents:    p.Documents,
+		docErrors:    p.docErrors,
+		FileOwner:    p.FileOwner,
+		Format:       p.Format,
+		turns:        p.turns,
+		instructions: p.instructions,
+		Output:       p.Output,
+		Prefill:      p.Prefill,
+		stops:        p.stops,
+		maxTokens:    p.maxTokens,
+	}
+	clone.Prompt.WriteString(p.Prompt.String())
+	clone.RootPrompt.WriteString(p.RootPrompt.String())
+	return clone
+}
+
+// format 返回使用的提示词格式
+func (p *ChatRequestProcessor) format() *PromptFormat {
+	if p.Format != nil {
+		return p.Format
+	}
+	return DefaultPromptFormat()
+}
+
+// header 返回提示词开头的说明
+func (p *ChatRequestProcessor) header() string {
+	if config.ConfigInstance.PromptDisableArtifacts {
+		return p.format().Artifacts()
+	}
+	return ""
+}
+
+// ProcessMessages processes the messages array into a prompt and extracts images
+func (p *ChatRequestProcessor) ProcessMessages(messages []map[string]interface{}) {
+	p.Prompt.WriteString(p.header())
+
+	// 以助手消息结尾时作为回复的开头，不作为一条消息
+	if n := len(messages); n > 1 && !config.ConfigInstance.NoPrefill {
+		if prefill, ok := prefillText(messages[n-1]); ok {
+			p.Prefill = prefill
+			messages = messages[:n-1]
+		}
+	}
+
+	for i, msg := range messages {
+		role, roleOk := msg["role"].(string)
+		if !roleOk {
+			continue // Skip invalid format
+		}
+
+		content, exists := msg["content"]
+		if !exists {
+			continue
+		}
+
+		docStart, imgStart := len(p.Documents), len(p.ImgDataList)
+		// 消息中的文本和文件标注，以空行分隔
+		var parts []string
+
+		switch v := content.(type) {
+		case string: // If content is directly a string
+			parts = append(parts, v)
+		case []interface{}: // If content is an array of []interface{} type
+			for _, item := range v {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					if itemType, ok := itemMap["type"].(string); ok {
+						if itemType == "text" {
+							if text, ok := itemMap["text"].(string); ok {
+								parts = append(parts, text)
+							}
+						} else if itemType == "image_url" {
+							if imageUrl, ok := itemMap["image_url"].(map[string]interface{}); ok {
+								if url, ok := imageUrl["url"].(string); ok {
+									p.ImgDataList = append(p.ImgDataList, url)
+								}
+							}
+						} else if itemType == "file" || itemType == "document" {
+							if marker, ok := p.addDocument(itemType, itemMap); ok {
+								parts = append(parts, marker)
+							}
+						}
+					}
+				}
+			}
+		}
+		text := p.format().Message(PromptMessage{
+			Role:    role,
+			Content: strings.Join(parts, "\n\n"),
+			Index:   i,
+			Last:    i == len(messages)-1,
+		})
+		p.Prompt.WriteString(text)
+		p.turns = append(p.turns, promptTurn{
+			Role:   role,
+			Text:   text,
+			Docs:   p.Documents[docStart:],
+			Images: p.ImgDataList[imgStart:],
+		})
+	}
+	p.RootPrompt.WriteString(p.Prompt.String())
+	if p.Prefill != "" {
+		p.AddInstruction(fmt.Sprintf(prefillInstruction, p.Prefill))
+	}
+	// Debug output
+	logger.Debug(fmt.Sprintf("Processed prompt: %s", p.Prompt.String()))
+	logger.Debug(fmt.Sprintf("Image data list: %v", p.ImgDataList))
+	logger.Debug(fmt.Sprintf("Documents: %d", len(p.Documents)))
+}
+
+const prefillInstruction = "Your reply has already been started with the text below. Continue it exactly from where it ends: do not repeat it and do not add any preamble.\n%s"
+
+// prefillText 返回只包含文本的助手消息的内容，内容为空白时返回 false
+func prefillText(msg map[string]interface{}) (string, bool) {
+	if msg["role"] != "assistant" {
+		return "", false
+	}
+	var text string
+	switch v := msg["content"].(type) {
+	case string:
+		text = v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok || itemMap["type"] != "text" {
+				return "", false
+			}
+			part, _ := itemMap["text"].(string)
+			parts = append(parts, part)
+		}
+		text = strings.Join(parts, "")
+	}
+	text = strings.TrimRight(text, " \t\r\n")
+	return text, strings.TrimSpace(text) != ""
+}
+
+// addDocument 解析文件内容，返回在提示词中标注文件位置的文本
+func (p *ChatRequestProcessor) addDocument(itemType string, item map[string]interface{}) (string, bool) {
+	var doc Document
+	var err error
+	if itemType == "file" {
+		doc, err = parseOpenAIFile(item, p.FileOwner)
+	} else {
+		doc, err = parseAnthropicDocument(item)
+	}
+	index := len(p.Documents) + len(p.docErrors)
+	if err != nil {
+		p.docErrors = append(p.docErrors, fmt.Errorf("file %d: %w", index+1, err))
+		return "", false
+	}
+	doc = doc.withDefaultName(index)
+	p.Documents = append(p.Documents, doc)
+	return fmt.Sprintf("[Attached file: %s]", doc.Name), true
+}
+
+// DocumentError 返回无法解析的文件的错误
+func (p *ChatRequestProcessor) DocumentError() error {
+	return errors.Join(p.docErrors...)
+}
+
+// UploadCount 返回需要上传的文件数量（图片和非文本文件）
+func (p *ChatRequestProcessor) UploadCount() int {
+	count := len(p.ImgDataList)
+	for _, doc := range p.Documents {
+		if !doc.IsText() {
+			count++
+		}
+	}
+	return count
+}
+
+// LargestUpload 返回需要上传的文件中最大一个的字节数（图片按解码后的大小估算）
+func (p *ChatRequestProcessor) LargestUpload() int64 {
+	var largest int64
+	for _, img := range p.ImgDataList {
+		_, data, _ := strings.Cut(img, ",")
+		largest = max(largest, int64(base64.StdEncoding.DecodedLen(len(data))))
+	}
+	for _, doc := range p.Documents {
+		if !doc.IsText() {
+			largest = max(largest, int64(len(doc.Data)))
+		}
+	}
+	return largest
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EstimateTokens 估算文本的 token 数：中日韩文字每字约 1 个 token，其他文字约 4 个字符 1 个 token
+func EstimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		// 全角标点
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// SplitByTokens 将文本按估算的 token 数切分为多段，尽量在换行处切分
+func SplitByTokens(text string, maxTokens int) []string {
+	if maxTokens <= 0 || EstimateTokens(text) <= maxTokens {
+		return []string{text}
+	}
+	var chunks []string
+	var current strings.Builder
+	currentTokens := 0
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentTokens = 0
+		}
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineTokens := EstimateTokens(line)
+		if currentTokens+lineTokens > maxTokens {
+			flush()
+		}
+		// 单行超过上限时按字符切分
+		for lineTokens > maxTokens {
+			cut := tokenCutIndex(line, maxTokens)
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+			lineTokens = EstimateTokens(line)
+		}
+		current.WriteString(line)
+		currentTokens += lineTokens
+	}
+	flush()
+	return chunks
+}
+
+// tokenCutIndex 返回估算 token 数不超过 maxTokens 的最长前缀的字节长度，至少包含一个字符
+func tokenCutIndex(text string, maxTokens int) int {
+	cjk, other := 0, 0
+	for i, r := range text {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+		if cjk+(other+3)/4 > maxTokens {
+			if i == 0 {
+				_, size := utf8.DecodeRuneInString(text)
+				return size
+			}
+			return i
+		}
+	}
+	return len(text)
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitByTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      []string
+	}{
+		{
+			name:      "no limit",
+			text:      "aaaa\nbbbb\n",
+			maxTokens: 0,
+			want:      []string{"aaaa\nbbbb\n"},
+		},
+		{
+			name:      "within limit",
+			text:      "short",
+			maxTokens: 10,
+			want:      []string{"short"},
+		},
+		{
+			name:      "split at newlines",
+			text:      "aaaa\nbbbb\ncccc\n",
+			maxTokens: 2,
+			want:      []string{"aaaa\n", "bbbb\n", "cccc\n"},
+		},
+		{
+			name:      "lines packed up to the limit",
+			text:      "aa\nbb\ncccccccc\n",
+			maxTokens: 2,
+			want:      []string{"aa\nbb\n", "cccccccc", "\n"},
+		},
+		{
+			name:      "long line split by characters",
+			text:      "abcdefghij",
+			maxTokens: 1,
+			want:      []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:      "cjk characters count one token each",
+			text:      "你好世界",
+			maxTokens: 2,
+			want:      []string{"你好", "世界"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitByTokens(tt.text, tt.maxTokens)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if joined := strings.Join(got, ""); joined != tt.text {
+				t.Errorf("chunks join to %q, want %q", joined, tt.text)
+			}
+			for _, chunk := range got {
+				if tt.maxTokens > 0 && EstimateTokens(chunk) > tt.maxTokens {
+					t.Errorf("chunk %q has %d tokens, limit %d", chunk, EstimateTokens(chunk), tt.maxTokens)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+var thinkingPattern = regexp.MustCompile(`(?s)^\s*<think>.*?</think>`)
+
+// TranscriptHash 计算消息列表的摘要，只考虑角色和内容
+func TranscriptHash(messages []map[string]interface{}) string {
+	h := sha256.New()
+	encoder := json.NewEncoder(h)
+	for _, msg := range messages {
+		// map 按键排序编码，内容相同的消息得到相同的摘要
+		encoder.Encode([]interface{}{msg["role"], msg["content"]})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MessageText 返回消息中的全部文本内容
+func MessageText(msg map[string]interface{}) string {
+	switch v := msg["content"].(type) {
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if itemMap, ok := item.(map[string]interface{}); ok && itemMap["type"] == "text" {
+				if text, ok := itemMap["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// NormalizeReply 去掉回复开头的 <think> 思考内容和首尾空白，用于比较客户端回传的助手消息
+func NormalizeReply(text string) string {
+	return strings.TrimSpace(thinkingPattern.ReplaceAllString(text, ""))
+}
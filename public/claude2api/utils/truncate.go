@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"strings"
+)
+
+// 上下文截断策略，见 ApplyContextStrategy
+const (
+	ContextStrategyNone       = "none"
+	ContextStrategyDropOldest = "drop_oldest"
+	ContextStrategyMiddleOut  = "middle_out"
+	ContextStrategySummarize  = "summarize"
+)
+
+// Summarizer 总结较早的消息，返回总结文本
+type Summarizer func(history string) (string, error)
+
+// ApplyContextStrategy 请求超出模型的上下文上限时按策略截断消息：
+//   - drop_oldest：从最早的消息开始丢弃，保留系统消息
+//   - middle_out：从中间开始丢弃，保留开头和最近的消息，并标注省略的消息数
+//   - summarize：把需要丢弃的消息交给 summarize 总结，以总结代替原消息；summarize 为空或失败时按 drop_oldest 处理
+//
+// 最后一条消息和系统消息不会被丢弃，丢弃的消息中的文件和图片一并去掉。
+// 策略为空或 none 时不做处理，截断后仍可能超出上限，由 CheckContextWindow 报告
+func (p *ChatRequestProcessor) ApplyContextStrategy(strategy, model string, summarize Summarizer) error {
+	switch strategy {
+	case "", ContextStrategyNone, ContextStrategyDropOldest, ContextStrategyMiddleOut, ContextStrategySummarize:
+	default:
+		return fmt.Errorf("unknown context strategy %q", strategy)
+	}
+	limit := config.ConfigInstance.ContextWindowFor(model)
+	tokens := p.EstimatedTokens()
+	if tokens <= limit || strategy == "" || strategy == ContextStrategyNone || len(p.turns) == 0 {
+		return nil
+	}
+
+	// 可丢弃的消息：除最后一条以外的非系统消息
+	var candidates []int
+	for i, turn := range p.turns[:len(p.turns)-1] {
+		if turn.Role != "system" {
+			candidates = append(candidates, i)
+		}
+	}
+	dropped := make(map[int]bool)
+	drop := func(i int) {
+		dropped[i] = true
+		tokens -= turnTokens(p.turns[i])
+	}
+
+	switch strategy {
+	case ContextStrategyMiddleOut:
+		// 为省略标注预留少量 token
+		for len(candidates) > 0 && tokens+10 > limit {
+			mid := len(candidates) / 2
+			drop(candidates[mid])
+			candidates = append(candidates[:mid], candidates[mid+1:]...)
+		}
+		p.dropTurns(dropped, func() *promptTurn {
+			return &promptTurn{Role: "note", Text: fmt.Sprintf("[%d earlier messages omitted]\n\n", len(dropped))}
+		})
+	case ContextStrategySummarize:
+		// 为总结预留上限的十分之一
+		target := limit - limit/10
+		for _, i := range candidates {
+			if tokens <= target {
+				break
+			}
+			drop(i)
+		}
+		var summary string
+		if summarize != nil && len(dropped) > 0 {
+			var history strings.Builder
+			for i, turn := range p.turns {
+				if dropped[i] {
+					history.WriteString(turn.Text)
+				}
+			}
+			var err error
+			summary, err = summarize(history.String())
+			if err != nil {
+				logger.Error(fmt.Sprintf("Failed to summarize %d messages, dropping them instead: %v", len(dropped), err))
+				summary = ""
+			}
+		}
+		p.dropTurns(dropped, func() *promptTurn {
+			if summary == "" {
+				return nil
+			}
+			return &promptTurn{Role: "system", Text: p.format().Message(PromptMessage{
+				Role:    "system",
+				Content: "Summary of the earlier conversation:\n" + strings.TrimSpace(summary),
+			})}
+		})
+	default:
+		for _, i := range candidates {
+			if tokens <= limit {
+				break
+			}
+			drop(i)
+		}
+		p.dropTurns(dropped, func() *promptTurn { return nil })
+	}
+	logger.Info(fmt.Sprintf("Context exceeds %d tokens of %s, dropped %d messages with strategy %s", limit, model, len(dropped), strategy))
+	return nil
+}
+
+// turnTokens 估算一条消息的 token 数，包括其中的文本文件
+func turnTokens(turn promptTurn) int {
+	tokens := EstimateTokens(turn.Text)
+	for _, doc := range turn.Docs {
+		if doc.IsText() {
+			tokens += EstimateTokens(string(doc.Data))
+		}
+	}
+	return tokens
+}
+
+// dropTurns 去掉指定的消息，在第一条被去掉的位置插入 replace 返回的消息（可为空），
+// 并重新生成提示词、文件和图片列表
+func (p *ChatRequestProcessor) dropTurns(dropped map[int]bool, replace func() *promptTurn) {
+	if len(dropped) == 0 {
+		return
+	}
+	var turns []promptTurn
+	inserted := false
+	for i, turn := range p.turns {
+		if !dropped[i] {
+			turns = append(turns, turn)
+			continue
+		}
+		if !inserted {
+			inserted = true
+			if r := replace(); r != nil {
+				turns = append(turns, *r)
+			}
+		}
+	}
+
+	p.turns = turns
+	p.Documents = nil
+	p.ImgDataList = []string{}
+	p.Prompt.Reset()
+	p.RootPrompt.Reset()
+	p.Prompt.WriteString(p.header())
+	for _, turn := range turns {
+		p.Prompt.WriteString(turn.Text)
+		p.Documents = append(p.Documents, turn.Docs...)
+		p.ImgDataList = append(p.ImgDataList, turn.Images...)
+	}
+	p.Prompt.WriteString(p.instructions)
+	p.RootPrompt.WriteString(p.Prompt.String())
+}